@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// VirtualMachineBackupBegin starts a backup of a running VM as described by a domainbackup xml file.
+// With incremental only blocks changed since that checkpoint are copied. With a checkpoint name a new checkpoint
+// is created at the same moment, to base the next incremental backup on.
+func VirtualMachineBackupBegin(vm string, backupXml string, incremental string, checkpointName string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	desc, err := ReadXMLFile(backupXml)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	if incremental != "" {
+		BackupXML, err := ParseXMLNode(desc)
+		herr(err)
+		if err != nil {
+			return
+		}
+		BackupXML.SetChildText("incremental", incremental)
+
+		desc, err = BackupXML.String()
+		herr(err)
+	}
+
+	var CheckpointDesc string
+	if checkpointName != "" {
+		desc, err := xml.Marshal(CheckpointXML{Name: checkpointName})
+		herr(err)
+		CheckpointDesc = string(desc)
+	}
+
+	err = d.BackupBegin(desc, CheckpointDesc, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret, err := GetVirtualMachineJobInfo(d)
+	herr(err)
+
+	hret(ret)
+}
+
+// VirtualMachineBackupEnd stops a running backup of a VM. A push backup that is not finished yet is cancelled.
+func VirtualMachineBackupEnd(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	JobInfo, err := GetVirtualMachineJobInfo(d)
+	herr(err)
+	if err != nil {
+		return
+	}
+	if !JobInfo.Active || JobInfo.Operation != "backup" {
+		herr(fmt.Errorf("%v has no running backup", vm))
+		return
+	}
+
+	// a backup has no call of its own to end it, it is a job like any other.
+	err = d.AbortJob()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("backup of %v was ended", vm))
+}
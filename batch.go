@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineBatchResult struct {
+	Vm    string
+	Ok    bool
+	Error string
+	// State is the state of the vm after the action, nil when the action failed or the state could not be read.
+	// In the latter case Ok is still set and Error tells why the state is missing.
+	State *VirtualMachineStateInfo
+}
+
+// VirtualMachineNames splits a comma separated --vm into vm names.
+func VirtualMachineNames(vms string) []string {
+	names := []string{}
+	for _, name := range strings.Split(vms, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// VirtualMachineMatcher returns a function telling whether a vm name matches a glob, e.g. test-*, or a regular
+// expression between slashes, e.g. /^test-[0-9]+$/. A bad pattern is an error here rather than on the first name.
+func VirtualMachineMatcher(pattern string) (func(name string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("bad --vm-pattern %v: %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	// path.Match checks the whole pattern, even when the name does not match.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad --vm-pattern %v: %v", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// MatchVirtualMachines returns names of all vms matching a --vm-pattern, see VirtualMachineMatcher.
+func MatchVirtualMachines(pattern string) ([]string, error) {
+	match, err := VirtualMachineMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	AllDomains, err := libvirtInstance.ListAllDomains(0)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, d := range AllDomains {
+		name, err := d.GetName()
+		d.Free()
+		if err != nil {
+			return nil, err
+		}
+		if match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// VirtualMachinesBatch runs an action on each vm in turn and returns a result per vm. A vm failing does not stop the rest.
+func VirtualMachinesBatch(vms []string, action func(vm string, d *libvirt.Domain) error) {
+	ret := []VirtualMachineBatchResult{}
+
+	for _, vm := range vms {
+		result := VirtualMachineBatchResult{Vm: vm}
+
+		d, err := libvirtInstance.LookupDomainByName(vm)
+		if err == nil {
+			err = action(vm, d)
+			d.Free()
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Ok = true
+			if VmState, err := ReadVirtualMachineStateInfo(vm); err != nil {
+				result.Error = fmt.Sprintf("reading state failed: %v", err)
+			} else {
+				result.State = &VmState
+			}
+		}
+		ret = append(ret, result)
+	}
+
+	hret(ret)
+}
+
+// ForEachParallel calls f for every index up to n on at most workers goroutines at once. Each call fills its own slot
+// of a results slice, which keeps results in order without locking.
+func ForEachParallel(n int, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// errShutdownDeadline tells that --timeout elapsed while waiting for a vm to shut down.
+var errShutdownDeadline = errors.New("did not shutdown before --timeout elapsed")
+
+// ShutdownVirtualMachine asks a vm to shut down. With a non zero timeout it waits for the vm to go down and kills it
+// if the guest ignores the request, forced tells whether it had to.
+func ShutdownVirtualMachine(ctx context.Context, vm string, d *libvirt.Domain, flags libvirt.DomainShutdownFlags, timeout time.Duration) (forced bool, err error) {
+	if err := d.ShutdownFlags(flags); err != nil || timeout == 0 {
+		return false, err
+	}
+
+	ShutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, reached := WaitForVirtualMachineState(ShutdownCtx, vm, VirtStateShutoff); reached {
+		return false, nil
+	}
+	if ctx.Err() != nil {
+		return false, errShutdownDeadline
+	}
+
+	// a transient vm that went down between the last poll and the kill is gone already.
+	err = d.Destroy()
+	if IsLibvirtError(err, libvirt.ERR_NO_DOMAIN) {
+		return false, nil
+	}
+	return true, err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVirtualMachineMatcher(t *testing.T) {
+	names := []string{"test-1", "test-12", "test-a", "prod-1", "mytest-1", "Test-2"}
+
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{pattern: "test-*", want: []string{"test-1", "test-12", "test-a"}},
+		{pattern: "test-?", want: []string{"test-1", "test-a"}},
+		{pattern: "*-1", want: []string{"test-1", "prod-1", "mytest-1"}},
+		{pattern: "[pP]rod-[0-9]", want: []string{"prod-1"}},
+		{pattern: "prod-1", want: []string{"prod-1"}},
+		{pattern: "nothing", want: nil},
+		// a glob matches the whole name, a regular expression any part of it unless anchored.
+		{pattern: "/test-[0-9]+/", want: []string{"test-1", "test-12", "mytest-1"}},
+		{pattern: "/^test-[0-9]+$/", want: []string{"test-1", "test-12"}},
+		{pattern: "/(?i)^test-/", want: []string{"test-1", "test-12", "test-a", "Test-2"}},
+		// a single slash is no regular expression, just a glob that matches nothing here.
+		{pattern: "/", want: nil},
+		{pattern: "test-[", wantErr: true},
+		{pattern: "[", wantErr: true},
+		{pattern: "/test-(/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		match, err := VirtualMachineMatcher(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("VirtualMachineMatcher(%q) error = %v, want error %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		var got []string
+		for _, name := range names {
+			if match(name) {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("VirtualMachineMatcher(%q) matched %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineBlockJob struct {
+	TargetDev      string
+	Type           string
+	BandwidthBytes uint64
+	Cur            uint64
+	End            uint64
+	Progress       float64 // percent
+	// Ready is set once a copy or an active commit caught up with the guest writes and can be pivoted.
+	Ready bool
+}
+
+var blockJobTypes = map[libvirt.DomainBlockJobType]string{
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_UNKNOWN:       "unknown",
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_PULL:          "pull",
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_COPY:          "copy",
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_COMMIT:        "commit",
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_ACTIVE_COMMIT: "active-commit",
+	libvirt.DOMAIN_BLOCK_JOB_TYPE_BACKUP:        "backup",
+}
+
+type VirtualMachineDiskSource struct {
+	TargetDev string
+	Source    *DomainDiskSourceXML
+}
+
+// BlockCopyDestXML describes where --block-copy mirrors a disk to.
+type BlockCopyDestXML struct {
+	XMLName xml.Name `xml:"disk"`
+	Type    string   `xml:"type,attr"`
+	Driver  struct {
+		Type string `xml:"type,attr"`
+	} `xml:"driver"`
+	Source DomainDiskSourceXML `xml:"source"`
+}
+
+// GetVirtualMachineBlockJob returns the block job running on a disk, or nil when there is none.
+func GetVirtualMachineBlockJob(d *libvirt.Domain, targetDev string) (*VirtualMachineBlockJob, error) {
+	info, err := d.GetBlockJobInfo(targetDev, libvirt.DOMAIN_BLOCK_JOB_INFO_BANDWIDTH_BYTES)
+	if err != nil {
+		return nil, err
+	}
+	// libvirt reports no job as a zeroed job info.
+	if info.Type == libvirt.DOMAIN_BLOCK_JOB_TYPE_UNKNOWN && info.End == 0 {
+		return nil, nil
+	}
+
+	BlockJob := &VirtualMachineBlockJob{
+		TargetDev:      targetDev,
+		Type:           blockJobTypes[info.Type],
+		BandwidthBytes: info.Bandwidth,
+		Cur:            info.Cur,
+		End:            info.End,
+	}
+	if info.End > 0 {
+		BlockJob.Progress = float64(info.Cur) * 100 / float64(info.End)
+	}
+	if info.Type == libvirt.DOMAIN_BLOCK_JOB_TYPE_COPY || info.Type == libvirt.DOMAIN_BLOCK_JOB_TYPE_ACTIVE_COMMIT {
+		BlockJob.Ready = info.End > 0 && info.Cur == info.End
+	}
+
+	return BlockJob, nil
+}
+
+// GetVirtualMachineBlockJobs returns block jobs running on any disk of a VM.
+func GetVirtualMachineBlockJobs(d *libvirt.Domain) ([]VirtualMachineBlockJob, error) {
+	BlockJobs := []VirtualMachineBlockJob{}
+
+	DomXML, err := GetDomainXML(d, 0)
+	if err != nil {
+		return BlockJobs, err
+	}
+
+	for _, disk := range DomXML.Devices.Disks {
+		BlockJob, err := GetVirtualMachineBlockJob(d, disk.Target.Dev)
+		if err != nil {
+			return BlockJobs, err
+		}
+		if BlockJob != nil {
+			BlockJobs = append(BlockJobs, *BlockJob)
+		}
+	}
+
+	return BlockJobs, nil
+}
+
+// VirtualMachineBlockCopy starts mirroring a disk of a running VM to a new file, or to an existing volume when a pool is given.
+// The VM keeps using the old disk until the copy is pivoted with VirtualMachineBlockJobPivot.
+func VirtualMachineBlockCopy(vm string, targetDev string, dest string, poolName string, volumeFormat string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if targetDev == "" || dest == "" {
+		herr(fmt.Errorf("--block-copy requires --target-dev and --dest"))
+		return
+	}
+
+	var DestXML BlockCopyDestXML
+	DestXML.Driver.Type = volumeFormat
+	flags := libvirt.DOMAIN_BLOCK_COPY_TRANSIENT_JOB
+
+	switch {
+	case poolName != "":
+		// volumes are created beforehand, e.g. with --volume-create, so qemu has to reuse them as they are.
+		DestXML.Type = "volume"
+		DestXML.Source = DomainDiskSourceXML{Pool: poolName, Volume: dest}
+		flags |= libvirt.DOMAIN_BLOCK_COPY_REUSE_EXT
+	case strings.HasPrefix(dest, "/dev/"):
+		DestXML.Type = "block"
+		DestXML.Source = DomainDiskSourceXML{Dev: dest}
+		flags |= libvirt.DOMAIN_BLOCK_COPY_REUSE_EXT
+	default:
+		DestXML.Type = "file"
+		DestXML.Source = DomainDiskSourceXML{File: dest}
+	}
+
+	desc, err := xml.Marshal(DestXML)
+	herr(err)
+
+	err = d.BlockCopy(targetDev, string(desc), &libvirt.DomainBlockCopyParameters{}, flags)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	BlockJob, err := GetVirtualMachineBlockJob(d, targetDev)
+	herr(err)
+
+	hret(BlockJob)
+}
+
+// VirtualMachineBlockJobPivot switches a disk over to the copy made by VirtualMachineBlockCopy, or to the base of
+// an active VirtualMachineBlockCommit, once it is in sync.
+func VirtualMachineBlockJobPivot(vm string, targetDev string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	BlockJob, err := GetVirtualMachineBlockJob(d, targetDev)
+	herr(err)
+	if err != nil {
+		return
+	}
+	if BlockJob == nil {
+		herr(fmt.Errorf("%v has no block job on %v", vm, targetDev))
+		return
+	}
+	if !BlockJob.Ready {
+		herr(fmt.Errorf("%v job on %v is not in sync yet, %.1f%% done", BlockJob.Type, targetDev, BlockJob.Progress))
+		return
+	}
+
+	err = d.BlockJobAbort(targetDev, libvirt.DOMAIN_BLOCK_JOB_ABORT_PIVOT)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineDiskSource{TargetDev: targetDev}
+	if disk := DomXML.FindDisk(targetDev); disk != nil {
+		ret.Source = disk.Source
+	}
+	hret(ret)
+}
+
+// WaitForBlockJob polls a block job with an exponential backoff until it is gone or ready to pivot,
+// or the context is done. The returned job is nil once it finished.
+func WaitForBlockJob(ctx context.Context, d *libvirt.Domain, targetDev string) (*VirtualMachineBlockJob, error) {
+	const maxBackoff = 5 * time.Second
+
+	backoff := 250 * time.Millisecond
+
+	for {
+		BlockJob, err := GetVirtualMachineBlockJob(d, targetDev)
+		if err != nil || BlockJob == nil || BlockJob.Ready {
+			return BlockJob, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return BlockJob, nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+type VirtualMachineBlockChain struct {
+	TargetDev string
+	// Job is nil once the job finished, an active commit stays around until it is pivoted.
+	Job   *VirtualMachineBlockJob
+	Chain []string
+}
+
+// VirtualMachineBlockCommit merges the overlays of a disk backing chain down into its base image.
+// By default every image below the active one is merged, the active one too with active set, in which case
+// the disk has to be switched to the base with VirtualMachineBlockJobPivot once the commit is in sync.
+// It waits for the job until the context is done and returns the chain as it is then.
+func VirtualMachineBlockCommit(ctx context.Context, vm string, targetDev string, active bool) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	// an empty top is the active image, which qemu only merges as a mirror that has to be pivoted.
+	top := targetDev + "[1]"
+	var flags libvirt.DomainBlockCommitFlags
+	if active {
+		top = ""
+		flags |= libvirt.DOMAIN_BLOCK_COMMIT_ACTIVE
+	}
+
+	err = d.BlockCommit(targetDev, "", top, 0, flags)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineBlockChain{TargetDev: targetDev, Chain: []string{}}
+	ret.Job, err = WaitForBlockJob(ctx, d, targetDev)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	if disk := DomXML.FindDisk(targetDev); disk != nil {
+		ret.Chain = disk.BackingChain()
+	}
+
+	hret(ret)
+}
+
+// VirtualMachineBlockPull copies the data of all backing images of a disk into its active image, so it no longer
+// depends on them. Bandwidth is in MiB/s, 0 is unlimited. It waits for the job, same as VirtualMachineBlockCommit.
+func VirtualMachineBlockPull(ctx context.Context, vm string, targetDev string, bandwidth uint64) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = d.BlockPull(targetDev, bandwidth, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineBlockChain{TargetDev: targetDev, Chain: []string{}}
+	ret.Job, err = WaitForBlockJob(ctx, d, targetDev)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	if disk := DomXML.FindDisk(targetDev); disk != nil {
+		ret.Chain = disk.BackingChain()
+	}
+
+	hret(ret)
+}
+
+// VirtualMachineBlockJobSpeed throttles a block job running on a disk. Bandwidth is in MiB/s, 0 lifts the limit.
+func VirtualMachineBlockJobSpeed(vm string, targetDev string, bandwidth uint64) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	// libvirt takes MiB/s unless told otherwise, but reports the limit back in bytes.
+	err = d.BlockJobSetSpeed(targetDev, bandwidth, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	BlockJob, err := GetVirtualMachineBlockJob(d, targetDev)
+	herr(err)
+
+	hret(BlockJob)
+}
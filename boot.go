@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineBootOrder struct {
+	// Order lists device types the firmware tries, Devices the boot order of single devices. Only one of them is set.
+	Order   []string
+	Devices []VirtualMachineBootDevice
+	// RestartNeeded is set while the vm runs, it boots the new way from its next start.
+	RestartNeeded bool
+}
+
+type VirtualMachineBootDevice struct {
+	// Target is the target device of a disk, e.g. vda, or the mac address of an interface.
+	Target string
+	Order  int
+}
+
+// bootDevTypes maps the device types of <os><boot dev> to a check whether a vm has such a device.
+var bootDevTypes = map[string]func(DomXML *XMLNode) bool{
+	"hd":      func(DomXML *XMLNode) bool { return hasDisk(DomXML, "disk", "lun") },
+	"cdrom":   func(DomXML *XMLNode) bool { return hasDisk(DomXML, "cdrom") },
+	"fd":      func(DomXML *XMLNode) bool { return hasDisk(DomXML, "floppy") },
+	"network": func(DomXML *XMLNode) bool { return len(DomXML.EnsureChild("devices").Children("interface")) > 0 },
+}
+
+// bootableDevices are the device elements that may carry a boot order of their own.
+var bootableDevices = []string{"disk", "interface", "hostdev", "redirdev"}
+
+func hasDisk(DomXML *XMLNode, devices ...string) bool {
+	for _, disk := range DomXML.EnsureChild("devices").Children("disk") {
+		for _, device := range devices {
+			// a disk without a device attribute is a plain disk.
+			if disk.Attr("device") == device || (device == "disk" && disk.Attr("device") == "") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bootTarget names a disk by its target device and an interface by its mac address.
+func bootTarget(device *XMLNode) string {
+	switch device.XMLName.Local {
+	case "disk":
+		if target := device.Child("target"); target != nil {
+			return target.Attr("dev")
+		}
+	case "interface":
+		if mac := device.Child("mac"); mac != nil {
+			return mac.Attr("address")
+		}
+	}
+	return ""
+}
+
+// VirtualMachineSetBootOrder sets which devices a VM boots from, either by device type, e.g. cdrom,hd,network, or by
+// single devices given as target=order, e.g. sda=1. libvirt does not allow both at once, so setting one drops the other.
+func VirtualMachineSetBootOrder(vm string, order string, bootDevs []string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if order != "" && len(bootDevs) > 0 {
+		herr(fmt.Errorf("--set-boot-order and --boot-dev can not be combined, libvirt allows only one of them"))
+		return
+	}
+
+	DeviceOrders := map[string]int{}
+	for _, bootDev := range bootDevs {
+		target, value, ok := strings.Cut(bootDev, "=")
+		position, err := strconv.Atoi(value)
+		if !ok || err != nil || position < 1 {
+			herr(fmt.Errorf("bad --boot-dev %v, use target=order, e.g. vda=1", bootDev))
+			return
+		}
+		DeviceOrders[target] = position
+	}
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		// adding one element may move the other, so both are there before holding on to them.
+		DomXML.EnsureChild("os")
+		devices, OsXML := DomXML.EnsureChild("devices"), DomXML.Child("os")
+		for _, name := range bootableDevices {
+			for _, device := range devices.Children(name) {
+				device.RemoveChildren("boot")
+			}
+		}
+		OsXML.RemoveChildren("boot")
+
+		if order != "" {
+			for _, dev := range strings.Split(order, ",") {
+				hasDevice, ok := bootDevTypes[dev]
+				if !ok {
+					return fmt.Errorf("unknown boot device %v, use hd, cdrom, network or fd", dev)
+				}
+				if !hasDevice(DomXML) {
+					return fmt.Errorf("%v has no %v device to boot from", vm, dev)
+				}
+				OsXML.Nodes = append(OsXML.Nodes, NewXMLNode("boot", "dev", dev))
+			}
+			return nil
+		}
+
+		found := map[string]bool{}
+		for _, name := range bootableDevices {
+			for _, device := range devices.Children(name) {
+				target := bootTarget(device)
+				if position, ok := DeviceOrders[target]; ok && target != "" {
+					device.Nodes = append(device.Nodes, NewXMLNode("boot", "order", strconv.Itoa(position)))
+					found[target] = true
+				}
+			}
+		}
+		for target := range DeviceOrders {
+			if !found[target] {
+				return fmt.Errorf("%v has no disk or interface %v", vm, target)
+			}
+		}
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetBootOrder(d)
+}
+
+// VirtualMachineGetBootOrder returns the boot order of a VM, by device type or by single devices.
+func VirtualMachineGetBootOrder(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineBootOrder{Order: []string{}, Devices: []VirtualMachineBootDevice{}, RestartNeeded: RestartNeeded(d)}
+	for _, boot := range DomXML.EnsureChild("os").Children("boot") {
+		ret.Order = append(ret.Order, boot.Attr("dev"))
+	}
+	devices := DomXML.EnsureChild("devices")
+	for _, name := range bootableDevices {
+		for _, device := range devices.Children(name) {
+			if boot := device.Child("boot"); boot != nil {
+				position, _ := strconv.Atoi(boot.Attr("order"))
+				ret.Devices = append(ret.Devices, VirtualMachineBootDevice{Target: bootTarget(device), Order: position})
+			}
+		}
+	}
+	sort.Slice(ret.Devices, func(i, j int) bool { return ret.Devices[i].Order < ret.Devices[j].Order })
+
+	hret(ret)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// CapabilitiesXML is a partial model of the libvirt host capabilities XML.
+type CapabilitiesXML struct {
+	Host struct {
+		Uuid string `xml:"uuid"`
+		Cpu  struct {
+			Arch     string `xml:"arch"`
+			Model    string `xml:"model"`
+			Vendor   string `xml:"vendor"`
+			Topology struct {
+				Sockets int `xml:"sockets,attr"`
+				Dies    int `xml:"dies,attr"`
+				Cores   int `xml:"cores,attr"`
+				Threads int `xml:"threads,attr"`
+			} `xml:"topology"`
+		} `xml:"cpu"`
+		Topology struct {
+			Cells []struct {
+				Id int `xml:"id,attr"`
+			} `xml:"cells>cell"`
+		} `xml:"topology"`
+		SecModels []struct {
+			Model string `xml:"model"`
+			Doi   string `xml:"doi"`
+		} `xml:"secmodel"`
+	} `xml:"host"`
+	Guests []struct {
+		OsType string `xml:"os_type"`
+		Arch   struct {
+			Name     string `xml:"name,attr"`
+			Emulator string `xml:"emulator"`
+			Machines []struct {
+				Name      string `xml:",chardata"`
+				Canonical string `xml:"canonical,attr"`
+			} `xml:"machine"`
+			Domains []struct {
+				Type string `xml:"type,attr"`
+			} `xml:"domain"`
+		} `xml:"arch"`
+	} `xml:"guest"`
+}
+
+// GetHostNumaCells returns ids of the host numa cells. They need not run from 0 without gaps, and a host
+// without numa topology has the single cell 0.
+func GetHostNumaCells() ([]int, error) {
+	desc, err := libvirtInstance.GetCapabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	var CapsXML CapabilitiesXML
+	if err = xml.Unmarshal([]byte(desc), &CapsXML); err != nil {
+		return nil, err
+	}
+
+	cells := []int{}
+	for _, cell := range CapsXML.Host.Topology.Cells {
+		cells = append(cells, cell.Id)
+	}
+	if len(cells) == 0 {
+		cells = append(cells, 0)
+	}
+	return cells, nil
+}
+
+type HostCapabilities struct {
+	Uuid           string
+	Arch           string
+	CpuModel       string
+	CpuVendor      string
+	Sockets        int
+	Dies           int
+	Cores          int
+	Threads        int
+	SecurityModels []string
+	Guests         []GuestCapabilities
+}
+
+type GuestCapabilities struct {
+	OsType      string
+	Arch        string
+	Emulator    string
+	DomainTypes []string
+	Machines    []string
+}
+
+// HostCapabilitiesInfo returns the host cpu, security models and guest architectures the hypervisor can run.
+// The libvirt xml is printed as it is with --format xml.
+func HostCapabilitiesInfo() {
+	desc, err := libvirtInstance.GetCapabilities()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	if *format == "xml" {
+		fmt.Print(desc)
+		hexit(0)
+	}
+
+	var CapsXML CapabilitiesXML
+	err = xml.Unmarshal([]byte(desc), &CapsXML)
+	herr(err)
+
+	ret := HostCapabilities{
+		Uuid:           CapsXML.Host.Uuid,
+		Arch:           CapsXML.Host.Cpu.Arch,
+		CpuModel:       CapsXML.Host.Cpu.Model,
+		CpuVendor:      CapsXML.Host.Cpu.Vendor,
+		Sockets:        CapsXML.Host.Cpu.Topology.Sockets,
+		Dies:           CapsXML.Host.Cpu.Topology.Dies,
+		Cores:          CapsXML.Host.Cpu.Topology.Cores,
+		Threads:        CapsXML.Host.Cpu.Topology.Threads,
+		SecurityModels: []string{},
+		Guests:         []GuestCapabilities{},
+	}
+	for _, model := range CapsXML.Host.SecModels {
+		ret.SecurityModels = append(ret.SecurityModels, model.Model)
+	}
+	for _, guest := range CapsXML.Guests {
+		GuestCaps := GuestCapabilities{OsType: guest.OsType, Arch: guest.Arch.Name, Emulator: guest.Arch.Emulator, DomainTypes: []string{}, Machines: []string{}}
+		for _, domain := range guest.Arch.Domains {
+			GuestCaps.DomainTypes = append(GuestCaps.DomainTypes, domain.Type)
+		}
+		for _, machine := range guest.Arch.Machines {
+			GuestCaps.Machines = append(GuestCaps.Machines, machine.Name)
+		}
+		ret.Guests = append(ret.Guests, GuestCaps)
+	}
+
+	hret(ret)
+}
+
+// DomainCapsEnumXML is a list of values a domain xml attribute may take.
+type DomainCapsEnumXML struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"value"`
+}
+
+// DomainCapabilitiesXML is a partial model of the libvirt domain capabilities XML.
+type DomainCapabilitiesXML struct {
+	Path    string `xml:"path"`
+	Domain  string `xml:"domain"`
+	Machine string `xml:"machine"`
+	Arch    string `xml:"arch"`
+	Vcpu    struct {
+		Max int `xml:"max,attr"`
+	} `xml:"vcpu"`
+	Os struct {
+		Enums  []DomainCapsEnumXML `xml:"enum"`
+		Loader struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"loader"`
+	} `xml:"os"`
+	Devices struct {
+		Disk struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"disk"`
+		Graphics struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"graphics"`
+		Video struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"video"`
+		Rng struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"rng"`
+		Tpm struct {
+			Enums []DomainCapsEnumXML `xml:"enum"`
+		} `xml:"tpm"`
+	} `xml:"devices"`
+}
+
+// domainCapsEnum returns the values of a named enum, never nil.
+func domainCapsEnum(enums []DomainCapsEnumXML, name string) []string {
+	for _, enum := range enums {
+		if enum.Name == name && enum.Values != nil {
+			return enum.Values
+		}
+	}
+	return []string{}
+}
+
+type DomainCapabilities struct {
+	Emulator      string
+	DomainType    string
+	Machine       string
+	Arch          string
+	MaxVcpus      int
+	Firmware      []string
+	DiskDevices   []string
+	DiskBuses     []string
+	GraphicsTypes []string
+	VideoModels   []string
+	RngModels     []string
+	TpmModels     []string
+}
+
+// DomainCapabilitiesInfo returns what a VM of a given architecture, machine type and emulator may use:
+// disk buses, video models, vcpu count and so on. Empty values let libvirt pick the host defaults.
+// The libvirt xml is printed as it is with --format xml.
+func DomainCapabilitiesInfo(emulator string, arch string, machine string, virtType string) {
+	desc, CapsXML, err := GetDomainCapabilities(emulator, arch, machine, virtType)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	if *format == "xml" {
+		fmt.Print(desc)
+		hexit(0)
+	}
+
+	hret(DomainCapabilities{
+		Emulator:      CapsXML.Path,
+		DomainType:    CapsXML.Domain,
+		Machine:       CapsXML.Machine,
+		Arch:          CapsXML.Arch,
+		MaxVcpus:      CapsXML.Vcpu.Max,
+		Firmware:      domainCapsEnum(CapsXML.Os.Enums, "firmware"),
+		DiskDevices:   domainCapsEnum(CapsXML.Devices.Disk.Enums, "diskDevice"),
+		DiskBuses:     domainCapsEnum(CapsXML.Devices.Disk.Enums, "bus"),
+		GraphicsTypes: domainCapsEnum(CapsXML.Devices.Graphics.Enums, "type"),
+		VideoModels:   domainCapsEnum(CapsXML.Devices.Video.Enums, "modelType"),
+		RngModels:     domainCapsEnum(CapsXML.Devices.Rng.Enums, "model"),
+		TpmModels:     domainCapsEnum(CapsXML.Devices.Tpm.Enums, "model"),
+	})
+}
+
+// GetVirtualMachineDomainCapabilities fetches the domain capabilities for the emulator, arch and machine of a vm.
+func GetVirtualMachineDomainCapabilities(DomXML *XMLNode) (DomainCapabilitiesXML, error) {
+	var emulator, arch, machine string
+	if devices := DomXML.Child("devices"); devices != nil && devices.Child("emulator") != nil {
+		emulator = devices.Child("emulator").Text
+	}
+	if OsXML := DomXML.Child("os"); OsXML != nil && OsXML.Child("type") != nil {
+		arch, machine = OsXML.Child("type").Attr("arch"), OsXML.Child("type").Attr("machine")
+	}
+
+	_, CapsXML, err := GetDomainCapabilities(emulator, arch, machine, DomXML.Attr("type"))
+	return CapsXML, err
+}
+
+// GetDomainCapabilities fetches the domain capabilities xml, both as it is and parsed.
+func GetDomainCapabilities(emulator string, arch string, machine string, virtType string) (string, DomainCapabilitiesXML, error) {
+	var CapsXML DomainCapabilitiesXML
+
+	desc, err := libvirtInstance.GetDomainCapabilities(emulator, arch, machine, virtType, 0)
+	if err != nil {
+		return desc, CapsXML, err
+	}
+
+	err = xml.Unmarshal([]byte(desc), &CapsXML)
+	return desc, CapsXML, err
+}
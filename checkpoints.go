@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/xml"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+// CheckpointXML is a partial model of the libvirt domain checkpoint XML.
+type CheckpointXML struct {
+	XMLName      xml.Name `xml:"domaincheckpoint"`
+	Name         string   `xml:"name,omitempty"`
+	CreationTime int64    `xml:"creationTime,omitempty"`
+	Parent       *struct {
+		Name string `xml:"name"`
+	} `xml:"parent"`
+}
+
+type VirtualMachineCheckpoint struct {
+	Name         string
+	Parent       string
+	CreationTime string
+}
+
+// VirtualMachineCheckpointCreate starts tracking blocks written to the disks of a running VM from now on,
+// so a later incremental backup only has to copy those. Without a name libvirt uses the creation time.
+func VirtualMachineCheckpointCreate(vm string, name string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	desc, err := xml.Marshal(CheckpointXML{Name: name})
+	herr(err)
+
+	checkpoint, err := d.CreateCheckpointXML(string(desc), 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer checkpoint.Free()
+
+	ret, err := GetVirtualMachineCheckpoint(checkpoint)
+	herr(err)
+
+	hret(ret)
+}
+
+// VirtualMachineCheckpointList returns checkpoints of a VM, oldest first.
+func VirtualMachineCheckpointList(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	AllCheckpoints, err := d.ListAllCheckpoints(libvirt.DOMAIN_CHECKPOINT_LIST_TOPOLOGICAL)
+	herr(err)
+
+	ret := []VirtualMachineCheckpoint{}
+	for _, checkpoint := range AllCheckpoints {
+		info, err := GetVirtualMachineCheckpoint(&checkpoint)
+		herr(err)
+		if err == nil {
+			ret = append(ret, info)
+		}
+		checkpoint.Free()
+	}
+
+	var rows [][]string
+	for _, info := range ret {
+		rows = append(rows, []string{info.Name, info.Parent, info.CreationTime})
+	}
+	htable(ret, []string{"NAME", "PARENT", "CREATED"}, rows)
+}
+
+func GetVirtualMachineCheckpoint(checkpoint *libvirt.DomainCheckpoint) (VirtualMachineCheckpoint, error) {
+	var Checkpoint VirtualMachineCheckpoint
+
+	desc, err := checkpoint.GetXMLDesc(libvirt.DOMAIN_CHECKPOINT_XML_NO_DOMAIN)
+	if err != nil {
+		return Checkpoint, err
+	}
+
+	var CpXML CheckpointXML
+	if err := xml.Unmarshal([]byte(desc), &CpXML); err != nil {
+		return Checkpoint, err
+	}
+
+	Checkpoint.Name = CpXML.Name
+	if CpXML.Parent != nil {
+		Checkpoint.Parent = CpXML.Parent.Name
+	}
+	Checkpoint.CreationTime = time.Unix(CpXML.CreationTime, 0).UTC().Format(time.RFC3339)
+
+	return Checkpoint, nil
+}
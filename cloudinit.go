@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"libvirt.org/go/libvirt"
+)
+
+// cloudInitMetadataUri namespaces the metadata that marks a vm whose cloud-init iso was created by the helper.
+const cloudInitMetadataUri = "https://github.com/dpvpro/libvirt-helper/cloud-init"
+
+// isoTools are the programs able to write a NoCloud iso, in the order they are tried.
+var isoTools = []string{"genisoimage", "mkisofs", "xorrisofs"}
+
+// CloudInitXML is the metadata element recording the cloud-init iso volume of a vm.
+type CloudInitXML struct {
+	XMLName xml.Name `xml:"cloud-init"`
+	Pool    string   `xml:"pool,attr"`
+	Volume  string   `xml:"volume,attr"`
+}
+
+// BuildCloudInitIso writes a NoCloud seed iso, labelled cidata, from user-data and meta-data files.
+// Without meta-data the instance id and hostname are set to the vm name.
+func BuildCloudInitIso(name string, userData string, metaData string) ([]byte, error) {
+	tool := ""
+	for _, candidate := range isoTools {
+		if path, err := exec.LookPath(candidate); err == nil {
+			tool = path
+			break
+		}
+	}
+	if tool == "" {
+		return nil, fmt.Errorf("building a cloud-init iso needs one of %v installed", isoTools)
+	}
+
+	dir, err := os.MkdirTemp("", "cidata")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	UserData, err := os.ReadFile(userData)
+	if err != nil {
+		return nil, err
+	}
+	MetaData := []byte(fmt.Sprintf("instance-id: %v\nlocal-hostname: %v\n", name, name))
+	if metaData != "" {
+		if MetaData, err = os.ReadFile(metaData); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "user-data"), UserData, 0600); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "meta-data"), MetaData, 0600); err != nil {
+		return nil, err
+	}
+
+	iso := filepath.Join(dir, "cidata.iso")
+	cmd := exec.Command(tool, "-output", iso, "-volid", "cidata", "-joliet", "-rock", "user-data", "meta-data")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v failed: %v: %s", filepath.Base(tool), err, out)
+	}
+
+	return os.ReadFile(iso)
+}
+
+// UploadCloudInitIso stores an iso as a new raw volume of a pool.
+func UploadCloudInitIso(poolName string, volumeName string, iso []byte) error {
+	pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+	if err != nil {
+		return err
+	}
+	defer pool.Free()
+
+	var VolXML StorageVolumeXML
+	VolXML.Name = volumeName
+	VolXML.Capacity.Unit = "bytes"
+	VolXML.Capacity.Value = uint64(len(iso))
+	VolXML.Target.Format = &StorageFormatXML{Type: "raw"}
+
+	desc, err := xml.Marshal(VolXML)
+	if err != nil {
+		return err
+	}
+
+	volume, err := pool.StorageVolCreateXML(string(desc), 0)
+	if err != nil {
+		return err
+	}
+	defer volume.Free()
+
+	stream, err := libvirtInstance.NewStream(0)
+	if err != nil {
+		volume.Delete(0)
+		return err
+	}
+	defer stream.Free()
+
+	err = volume.Upload(stream, 0, uint64(len(iso)), 0)
+	if err == nil {
+		sent := 0
+		err = stream.SendAll(func(s *libvirt.Stream, n int) ([]byte, error) {
+			chunk := iso[sent:]
+			if len(chunk) > n {
+				chunk = chunk[:n]
+			}
+			sent += len(chunk)
+			return chunk, nil
+		})
+		if err == nil {
+			err = stream.Finish()
+		} else {
+			stream.Abort()
+		}
+	}
+	if err != nil {
+		volume.Delete(0)
+	}
+	return err
+}
+
+// AttachCloudInitIso adds the iso volume to a domain xml as a read-only sata cdrom, on the first free sd target.
+func AttachCloudInitIso(DomainXml string, poolName string, volumeName string) (string, error) {
+	Node, err := ParseXMLNode(DomainXml)
+	if err != nil {
+		return "", err
+	}
+
+	devices := Node.EnsureChild("devices")
+
+	used := map[string]bool{}
+	for _, disk := range devices.Children("disk") {
+		if target := disk.Child("target"); target != nil {
+			used[target.Attr("dev")] = true
+		}
+	}
+	dev := ""
+	for c := 'a'; c <= 'z'; c++ {
+		if !used["sd"+string(c)] {
+			dev = "sd" + string(c)
+			break
+		}
+	}
+	if dev == "" {
+		return "", errors.New("no free sd target left for the cloud-init iso")
+	}
+
+	disk := NewXMLNode("disk", "type", "volume", "device", "cdrom")
+	disk.Nodes = []XMLNode{
+		NewXMLNode("driver", "name", "qemu", "type", "raw"),
+		NewXMLNode("source", "pool", poolName, "volume", volumeName),
+		NewXMLNode("target", "dev", dev, "bus", "sata"),
+		NewXMLNode("readonly"),
+	}
+	devices.Nodes = append(devices.Nodes, disk)
+
+	return Node.String()
+}
+
+// CloudInitVolumeName is the name of the cloud-init iso volume of a vm.
+func CloudInitVolumeName(vm string) string {
+	return vm + "-cidata.iso"
+}
+
+// PrepareCloudInitIso builds the cloud-init iso of the vm a domain xml describes, uploads it into a pool and
+// returns the xml with the iso attached.
+func PrepareCloudInitIso(DomainXml string, poolName string, userData string, metaData string) (string, CloudInitXML, error) {
+	Node, err := ParseXMLNode(DomainXml)
+	if err != nil {
+		return "", CloudInitXML{}, err
+	}
+	name := Node.Child("name")
+	if name == nil || name.Text == "" {
+		return "", CloudInitXML{}, errors.New("the domain xml has no name to build a cloud-init iso for")
+	}
+	CloudInit := CloudInitXML{Pool: poolName, Volume: CloudInitVolumeName(name.Text)}
+
+	iso, err := BuildCloudInitIso(name.Text, userData, metaData)
+	if err != nil {
+		return "", CloudInit, err
+	}
+	if err = UploadCloudInitIso(CloudInit.Pool, CloudInit.Volume, iso); err != nil {
+		return "", CloudInit, err
+	}
+
+	DomainXml, err = AttachCloudInitIso(DomainXml, CloudInit.Pool, CloudInit.Volume)
+	if err != nil {
+		DeleteCloudInitIso(CloudInit)
+	}
+	return DomainXml, CloudInit, err
+}
+
+// MarkCloudInitIso records the iso volume in the vm metadata, so --delete knows to remove it.
+func MarkCloudInitIso(d *libvirt.Domain, CloudInit CloudInitXML, transient bool) error {
+	desc, err := xml.Marshal(CloudInit)
+	if err != nil {
+		return err
+	}
+
+	flags := libvirt.DOMAIN_AFFECT_CONFIG
+	if transient {
+		flags = libvirt.DOMAIN_AFFECT_LIVE
+	}
+	return d.SetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, string(desc), "helper", cloudInitMetadataUri, flags)
+}
+
+// GetCloudInitIso returns the cloud-init iso volume the helper created for a vm, if any.
+func GetCloudInitIso(d *libvirt.Domain) (CloudInitXML, bool) {
+	var CloudInit CloudInitXML
+
+	desc, err := d.GetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, cloudInitMetadataUri, libvirt.DOMAIN_AFFECT_CURRENT)
+	if err != nil {
+		return CloudInit, false
+	}
+	if err = xml.Unmarshal([]byte(desc), &CloudInit); err != nil {
+		herr(err)
+		return CloudInit, false
+	}
+
+	return CloudInit, CloudInit.Volume != ""
+}
+
+// DeleteCloudInitIso removes the cloud-init iso volume and returns its path.
+func DeleteCloudInitIso(CloudInit CloudInitXML) (string, error) {
+	pool, err := libvirtInstance.LookupStoragePoolByName(CloudInit.Pool)
+	if err != nil {
+		return "", err
+	}
+	defer pool.Free()
+
+	volume, err := pool.LookupStorageVolByName(CloudInit.Volume)
+	if err != nil {
+		return "", err
+	}
+	defer volume.Free()
+
+	path, err := volume.GetPath()
+	if err != nil {
+		return "", err
+	}
+	return path, volume.Delete(0)
+}
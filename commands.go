@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// Command is a subcommand like "vm state". It maps to one of the action flags, which keep working on their own,
+// and owns the flags it takes, so a flag meant for another command is caught instead of silently ignored.
+type Command struct {
+	Name string
+	// Action is the action flag the command sets. Empty when the command is selected by its own flags, e.g. --set-title.
+	Action string
+	// Selects are flags that select a command without an action flag, any of them may be combined.
+	Selects []string
+	// Arg names the positional argument that becomes the value of a non boolean action flag, e.g. the keys of send-key.
+	Arg   string
+	Flags []string
+	// ReadOnly commands change nothing, only these run over a --readonly connection.
+	ReadOnly bool
+	// Required flags must be given for the command to run, "vm" is also satisfied by --vm-pattern, --uuid or --id.
+	Required []string
+	// Help describes commands without an action flag, the others use the usage of the flag.
+	Help string
+}
+
+// globalFlags are accepted by every command.
+var globalFlags = []string{"config", "log-level", "timing", "connect", "readonly", "timeout", "format", "vm", "vm-pattern", "dry-run", "uuid", "id"}
+
+var commands = []Command{
+	{Name: "vm state", Action: "state", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "vm start", Action: "start", Required: []string{"vm"}},
+	{Name: "vm shutdown", Action: "shutdown", Flags: []string{"shutdown-mode", "shutdown-timeout"}, Required: []string{"vm"}},
+	{Name: "vm shutoff", Action: "shutoff", Required: []string{"vm"}},
+	{Name: "vm soft-reboot", Action: "soft-reboot", Flags: []string{"reboot-mode"}, Required: []string{"vm"}},
+	{Name: "vm hard-reboot", Action: "hard-reboot", Required: []string{"vm"}},
+	{Name: "vm pause", Action: "pause", Required: []string{"vm"}},
+	{Name: "vm resume", Action: "resume", Required: []string{"vm"}},
+	{Name: "vm create", Action: "create", Flags: []string{"xml-template", "set", "set-file", "transient", "start-after-create", "no-validate",
+		"cloud-init-user-data", "cloud-init-meta-data", "pool"}, Required: []string{"xml-template"}},
+	{Name: "vm generate-template", Action: "generate-template", Flags: []string{"name", "memory", "vcpus", "disk-path", "network", "os-variant"},
+		ReadOnly: true, Required: []string{"name"}},
+	{Name: "vm delete", Action: "delete", Flags: []string{"remove-storage", "remove-nvram", "force"}, Required: []string{"vm"}},
+	{Name: "vm list", Action: "show-all", Flags: []string{"filter-state", "with-memory", "with-cpu", "with-vcpus", "concurrency"}, ReadOnly: true},
+	{Name: "vm ips", Action: "ips", Flags: []string{"ip-source", "include-loopback", "concurrency"}, ReadOnly: true},
+	{Name: "vm net-stats", Action: "net-stats", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "vm metrics", Action: "metrics", ReadOnly: true},
+	{Name: "vm set-boot-order", Selects: []string{"set-boot-order", "boot-dev"},
+		Help: "sets the boot order of a vm by device type or by single devices. Returns result with the boot order", Required: []string{"vm"}},
+	{Name: "vm set-firmware", Selects: []string{"set-firmware", "secure-boot", "boot-menu"},
+		Help: "switches a vm between efi and bios, secure boot and the boot menu on or off. Returns result with the firmware config", Required: []string{"vm"}},
+	{Name: "vm add-tpm", Action: "add-tpm", Flags: []string{"tpm-version", "tpm-model"}, Required: []string{"vm"}},
+	{Name: "vm remove-tpm", Action: "remove-tpm", Required: []string{"vm"}},
+	{Name: "vm set-watchdog", Action: "set-watchdog", Flags: []string{"watchdog-model", "watchdog-action", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "vm add-rng", Action: "add-rng", Flags: []string{"rng-backend", "rng-rate-bytes", "rng-rate-period", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "vm set-video", Action: "set-video", Flags: []string{"video-model", "vram"}, Required: []string{"vm", "video-model"}},
+	{Name: "vm wait-for", Action: "wait-for", Arg: "state", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "vm watch-events", Action: "watch-events", Flags: []string{"keepalive-interval", "keepalive-count"}, ReadOnly: true},
+	{Name: "vm screenshot", Action: "screenshot", Flags: []string{"output", "screen"}, Required: []string{"vm"}},
+	{Name: "vm send-key", Action: "send-key", Arg: "keys", Flags: []string{"hold-time"}, Required: []string{"vm"}},
+	{Name: "vm graphics-info", Action: "graphics-info", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "vm set-graphics-password", Action: "set-graphics-password", Flags: []string{"password", "validity", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "vm set-metadata", Selects: []string{"set-title", "set-description"}, Flags: []string{"live", "persistent"},
+		Help: "sets a title and a description of a vm. Returns result with a current metadata", Required: []string{"vm"}},
+	{Name: "vm get-metadata", Action: "get-metadata", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "vm attach-device", Action: "attach-device", Flags: []string{"device-xml", "live", "persistent"}, Required: []string{"vm", "device-xml"}},
+	{Name: "vm detach-device", Action: "detach-device", Flags: []string{"device-xml", "live", "persistent"}, Required: []string{"vm", "device-xml"}},
+	{Name: "vm attach-hostdev", Action: "attach-hostdev", Flags: []string{"pci-address", "live", "persistent"}, Required: []string{"vm", "pci-address"}},
+	{Name: "vm detach-hostdev", Action: "detach-hostdev", Flags: []string{"pci-address", "live", "persistent"}, Required: []string{"vm", "pci-address"}},
+	{Name: "vm attach-usb", Action: "attach-usb", Flags: []string{"vendor", "product", "usb-address", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "vm detach-usb", Action: "detach-usb", Flags: []string{"vendor", "product", "usb-address", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "vm set-usb-redir", Action: "set-usb-redir", Arg: "count", Required: []string{"vm"}},
+	{Name: "vm attach-vf", Action: "attach-vf", Flags: []string{"pf", "vf-mode", "live", "persistent"}, Required: []string{"vm", "pf"}},
+
+	{Name: "disk resize", Action: "block-resize", Flags: []string{"target-dev", "size"}, Required: []string{"vm", "target-dev", "size"}},
+	{Name: "disk change-media", Action: "change-media", Flags: []string{"target-dev", "source", "live", "persistent"}, Required: []string{"vm", "target-dev"}},
+	{Name: "disk eject", Action: "eject", Flags: []string{"target-dev", "live", "persistent"}, Required: []string{"vm", "target-dev"}},
+	{Name: "disk copy", Action: "block-copy", Flags: []string{"target-dev", "dest", "pool", "volume-format"}, Required: []string{"vm", "target-dev", "dest"}},
+	{Name: "disk commit", Action: "block-commit", Flags: []string{"target-dev", "active"}, Required: []string{"vm", "target-dev"}},
+	{Name: "disk pull", Action: "block-pull", Flags: []string{"target-dev", "bandwidth"}, Required: []string{"vm", "target-dev"}},
+	{Name: "disk pivot", Action: "block-job-pivot", Flags: []string{"target-dev"}, Required: []string{"vm", "target-dev"}},
+	{Name: "disk job-speed", Action: "block-job-speed", Flags: []string{"target-dev", "bandwidth"}, Required: []string{"vm", "target-dev"}},
+
+	{Name: "job info", Action: "job-info", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "job abort", Action: "job-abort", Required: []string{"vm"}},
+
+	{Name: "guest info", Action: "guest-info", Required: []string{"vm"}},
+	{Name: "guest hostname", Action: "guest-hostname", Required: []string{"vm"}},
+	{Name: "guest agent-ping", Action: "agent-ping", Required: []string{"vm"}},
+	{Name: "guest fs-freeze", Action: "fs-freeze", Flags: []string{"mountpoint"}, Required: []string{"vm"}},
+	{Name: "guest fs-thaw", Action: "fs-thaw", Flags: []string{"mountpoint"}, Required: []string{"vm"}},
+	{Name: "guest fs-trim", Action: "fs-trim", Flags: []string{"mountpoint", "minimum"}, Required: []string{"vm"}},
+	{Name: "guest fs-info", Action: "fs-info", Required: []string{"vm"}},
+	{Name: "guest set-time", Action: "set-time", Flags: []string{"sync", "epoch"}, Required: []string{"vm"}},
+	{Name: "guest pm-suspend", Action: "pm-suspend", Flags: []string{"target", "wakeup-after"}, Required: []string{"vm"}},
+	{Name: "guest set-user-ssh-keys", Action: "set-user-ssh-keys", Flags: []string{"guest-user", "ssh-key-file", "append"},
+		Required: []string{"vm", "guest-user", "ssh-key-file"}},
+	{Name: "guest exec", Action: "guest-exec", Flags: []string{"cmd", "arg"}, Required: []string{"vm", "cmd"}},
+	{Name: "guest pm-wakeup", Action: "pm-wakeup", Required: []string{"vm"}},
+
+	{Name: "checkpoint create", Action: "checkpoint-create", Flags: []string{"checkpoint-name"}, Required: []string{"vm"}},
+	{Name: "checkpoint list", Action: "checkpoint-list", ReadOnly: true, Required: []string{"vm"}},
+	{Name: "backup begin", Action: "backup-begin", Flags: []string{"backup-xml", "incremental", "checkpoint-name"}, Required: []string{"vm", "backup-xml"}},
+	{Name: "backup end", Action: "backup-end", Required: []string{"vm"}},
+
+	{Name: "tune set-scheduler", Selects: []string{"set-cpu-shares", "set-cpu-quota", "set-cpu-period"}, Flags: []string{"live", "persistent"},
+		Help: "sets cpu shares, quota and period of a vm. Returns result with current scheduler values", Required: []string{"vm"}},
+	{Name: "tune get-scheduler", Action: "get-scheduler", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "tune set-blkio", Selects: []string{"set-blkio-weight", "set-device-iops"}, Flags: []string{"read-iops", "write-iops", "force", "live", "persistent"},
+		Help: "sets the block io weight of a vm and iops limits of its host block devices. Returns result with current blkio values", Required: []string{"vm"}},
+	{Name: "tune get-blkio", Action: "get-blkio", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "tune set-memtune", Selects: []string{"set-memory-hard-limit", "set-memory-soft-limit", "set-swap-hard-limit"}, Flags: []string{"live", "persistent"},
+		Help: "sets host memory limits of a vm. Returns result with current limits", Required: []string{"vm"}},
+	{Name: "tune get-memtune", Action: "get-memtune", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "tune set-disk-iotune", Action: "set-disk-iotune",
+		Flags: []string{"target-dev", "total-bytes-sec", "read-bytes-sec", "write-bytes-sec", "total-iops", "read-iops", "write-iops", "live", "persistent"}, Required: []string{"vm", "target-dev"}},
+	{Name: "tune set-iothreads", Selects: []string{"set-iothreads", "assign-iothread"},
+		Help: "sets how many iothreads a vm has and which disks they serve. Returns result with disks and their iothreads", Required: []string{"vm"}},
+	{Name: "tune set-numatune", Action: "set-numatune", Flags: []string{"numa-mode", "nodeset", "live", "persistent"}, Required: []string{"vm"}},
+	{Name: "tune get-numatune", Action: "get-numatune", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "tune set-cpu-model", Action: "set-cpu-model", Arg: "model", Flags: []string{"cpu-feature"}, Required: []string{"vm"}},
+	{Name: "tune pin-emulator", Action: "pin-emulator", Flags: []string{"cpu-set", "live", "persistent"}, Required: []string{"vm", "cpu-set"}},
+	{Name: "tune get-emulator-pin", Action: "get-emulator-pin", Flags: []string{"live", "persistent"}, ReadOnly: true, Required: []string{"vm"}},
+	{Name: "tune get-disk-iotune", Action: "get-disk-iotune", Flags: []string{"target-dev", "live", "persistent"}, ReadOnly: true, Required: []string{"vm", "target-dev"}},
+
+	{Name: "pool list", Action: "pool-list", ReadOnly: true},
+	{Name: "pool refresh", Action: "pool-refresh", Flags: []string{"pool"}},
+	{Name: "volume list", Action: "volume-list", Flags: []string{"pool"}, ReadOnly: true, Required: []string{"pool"}},
+	{Name: "volume create", Action: "volume-create", Flags: []string{"pool", "volume-name", "size", "volume-format", "backing-vol"}, Required: []string{"pool", "volume-name", "size"}},
+	{Name: "volume delete", Action: "volume-delete", Flags: []string{"pool", "volume-name", "wipe", "force"}, Required: []string{"pool", "volume-name"}},
+	{Name: "volume resize", Action: "volume-resize", Flags: []string{"pool", "volume-name", "size", "delta", "shrink"}, Required: []string{"pool", "volume-name", "size"}},
+
+	{Name: "network list", Action: "network-list", ReadOnly: true},
+	{Name: "network leases", Action: "network-leases", Flags: []string{"network"}, ReadOnly: true, Required: []string{"network"}},
+	{Name: "network create", Action: "network-create", Flags: []string{"xml-template", "start-network", "autostart"}, Required: []string{"xml-template"}},
+	{Name: "network destroy", Action: "network-destroy", Flags: []string{"network", "force"}, Required: []string{"network"}},
+	{Name: "network undefine", Action: "network-undefine", Flags: []string{"network"}, Required: []string{"network"}},
+
+	{Name: "host info", Action: "host-info", ReadOnly: true},
+	{Name: "host version", Action: "version", ReadOnly: true},
+	// the server refuses changes itself with --readonly.
+	{Name: "host serve", Action: "serve", Arg: "address", Flags: []string{"concurrency", "include-loopback", "keepalive-interval", "keepalive-count", "serve-token-file"}, ReadOnly: true},
+	{Name: "host memory", Action: "host-memory", ReadOnly: true},
+	{Name: "host cpu-stats", Action: "host-cpu-stats", Flags: []string{"per-cpu"}, ReadOnly: true},
+	{Name: "host capabilities", Action: "capabilities", ReadOnly: true},
+	{Name: "host domain-capabilities", Action: "domain-capabilities", Flags: []string{"arch", "machine", "emulator", "virt-type"}, ReadOnly: true},
+
+	{Name: "nodedev list", Action: "node-device-list", Flags: []string{"cap"}, ReadOnly: true},
+	{Name: "nodedev xml", Action: "node-device-xml", Arg: "name", ReadOnly: true},
+	{Name: "nodedev list-vfs", Action: "list-vfs", Flags: []string{"pf"}, ReadOnly: true, Required: []string{"pf"}},
+}
+
+// FindCommand returns the command named by the first two arguments, and the arguments left after it.
+func FindCommand(args []string) (*Command, []string) {
+	if len(args) < 2 {
+		return nil, args
+	}
+
+	for i := range commands {
+		if commands[i].Name == args[0]+" "+args[1] {
+			return &commands[i], args[2:]
+		}
+	}
+	return nil, args
+}
+
+// Owns reports whether a flag may be passed to the command.
+func (cmd *Command) Owns(name string) bool {
+	if name == cmd.Action {
+		return true
+	}
+	for _, flag := range append(append(globalFlags, cmd.Selects...), cmd.Flags...) {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DispatchCommand runs a subcommand by setting its action flag, after making sure every flag given belongs to it.
+func DispatchCommand(args []string) error {
+	cmd, rest := FindCommand(args)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %v, see --help", strings.Join(args, " "))
+	}
+
+	var err error
+	pflag.Visit(func(flag *pflag.Flag) {
+		if err == nil && !cmd.Owns(flag.Name) {
+			err = fmt.Errorf("--%v is not a flag of %v, see %v --help", flag.Name, cmd.Name, cmd.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cmd.Arg != "":
+		if len(rest) != 1 {
+			return fmt.Errorf("%v takes exactly one argument, <%v>", cmd.Name, cmd.Arg)
+		}
+		return pflag.Set(cmd.Action, rest[0])
+	case len(rest) > 0:
+		return fmt.Errorf("%v takes no arguments, got %v", cmd.Name, strings.Join(rest, " "))
+	case cmd.Action != "":
+		return pflag.Set(cmd.Action, "true")
+	}
+	return nil
+}
+
+// SelectedBy reports whether a command was asked for by its action flag or one of its selecting flags.
+func (cmd *Command) SelectedBy(name string) bool {
+	if name == cmd.Action {
+		return true
+	}
+	for _, flag := range cmd.Selects {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectedCommand returns the command asked for by the action flags, or nil. With several action flags only the first one
+// in the switch would run, which is never what the caller meant, so that is an error.
+func SelectedCommand() (*Command, error) {
+	var selected []*Command
+	var flags []string
+
+	pflag.Visit(func(flag *pflag.Flag) {
+		for i := range commands {
+			if !commands[i].SelectedBy(flag.Name) {
+				continue
+			}
+			flags = append(flags, "--"+flag.Name)
+			if len(selected) == 0 || selected[len(selected)-1] != &commands[i] {
+				selected = append(selected, &commands[i])
+			}
+		}
+	})
+
+	if len(selected) == 0 {
+		return nil, nil
+	}
+	for i := 1; i < len(selected); i++ {
+		if selected[i] != selected[0] {
+			return nil, fmt.Errorf("conflicting actions %v, pass only one of them", strings.Join(flags, ", "))
+		}
+	}
+	return selected[0], nil
+}
+
+// CheckRequired makes sure every flag the command needs has a value, from the command line or the config file,
+// rather than letting libvirt fail on an empty name.
+func (cmd *Command) CheckRequired() error {
+	for _, name := range cmd.Required {
+		given := pflag.Lookup(name).Value.String() != ""
+		if name == "vm" {
+			given = given || *vmPattern != "" || *vmUuid != "" || *vmId >= 0
+		}
+		if !given {
+			return fmt.Errorf("--%v is required for %v", name, cmd.Name)
+		}
+	}
+	return nil
+}
+
+// PrintUsage prints help of the command given before --help, or the list of all commands.
+func PrintUsage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if cmd, _ := FindCommand(pflag.Args()); cmd != nil {
+		usage := fmt.Sprintf("usage: %v %v [flags]", os.Args[0], cmd.Name)
+		if cmd.Arg != "" {
+			usage = fmt.Sprintf("usage: %v %v <%v> [flags]", os.Args[0], cmd.Name, cmd.Arg)
+		}
+		fmt.Fprintf(w, "%v\n\n%v\n\nflags:\n", usage, cmd.Description())
+
+		flags := pflag.NewFlagSet(cmd.Name, pflag.ContinueOnError)
+		for _, name := range append(append(cmd.Selects, cmd.Flags...), globalFlags...) {
+			flags.AddFlag(pflag.Lookup(name))
+		}
+		flags.SortFlags = false
+		fmt.Fprint(w, flags.FlagUsages())
+		return
+	}
+
+	fmt.Fprintf(w, "usage: %v <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %v\t%v\n", cmd.Name, cmd.Description())
+	}
+	fmt.Fprint(w, "\nevery command is also available as a flag, e.g. --state for vm state. All flags:\n")
+	fmt.Fprint(w, pflag.CommandLine.FlagUsages())
+}
+
+func (cmd *Command) Description() string {
+	if cmd.Action == "" {
+		return cmd.Help
+	}
+	return pflag.Lookup(cmd.Action).Usage
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// DefaultConfigPath is where defaults are read from when --config is not given, e.g. ~/.config/libvirt-helper.yaml.
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "libvirt-helper.yaml")
+}
+
+// configKeys are the flags a config file may set. These are connection and output defaults only, a file can
+// not pick a command, turn on anything destructive like --force or --remove-storage, or pick what a command works
+// on like --pool, --network or --guest-user, so a --network-destroy never hits a network the user did not name.
+var configKeys = map[string]bool{
+	"connect": true, "readonly": true, "format": true, "log-level": true, "timing": true,
+	"timeout": true, "shutdown-timeout": true, "shutdown-mode": true, "reboot-mode": true,
+	"ip-source": true, "include-loopback": true, "concurrency": true,
+	"with-memory": true, "with-cpu": true, "with-vcpus": true,
+	"volume-format": true, "keepalive-interval": true, "keepalive-count": true,
+	"arch": true, "machine": true, "emulator": true, "virt-type": true,
+}
+
+// configured are the flags a config file set.
+var configured = map[string]bool{}
+
+// FlagGiven tells whether a flag was set on the command line or in the config file, rather than left at its default.
+func FlagGiven(name string) bool {
+	return pflag.CommandLine.Changed(name) || configured[name]
+}
+
+// LoadConfig sets flag defaults from a config file. Flags given on the command line win over the file.
+// The file is a flat yaml map of flag names to values, e.g.
+//
+//	connect: qemu+ssh://root@host/system
+//	format: table
+//	volume-format: raw
+//	shutdown-timeout: 2m
+//
+// Only the settings in configKeys are taken. A missing file is fine unless it was asked for explicitly.
+func LoadConfig(path string, explicit bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return fmt.Errorf("%v:%d: expected key: value", path, line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		flag := pflag.CommandLine.Lookup(key)
+		if flag == nil {
+			return fmt.Errorf("%v:%d: unknown setting %v", path, line, key)
+		}
+		if !configKeys[key] {
+			return fmt.Errorf("%v:%d: %v can only be given on the command line", path, line, key)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return fmt.Errorf("%v:%d: bad value of %v: %v", path, line, key, err)
+		}
+		configured[key] = true
+	}
+
+	return scanner.Err()
+}
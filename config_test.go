@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// resetConfigFlags puts the flags and settings LoadConfig touched back to their defaults.
+func resetConfigFlags(t *testing.T) {
+	pflag.CommandLine.VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || configured[flag.Name] {
+			if err := flag.Value.Set(flag.DefValue); err != nil {
+				t.Fatalf("failed to reset --%v: %v", flag.Name, err)
+			}
+			flag.Changed = false
+		}
+	})
+	configured = map[string]bool{}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "settings", config: "---\n# defaults\nconnect: qemu+ssh://root@host/system\nvolume-format: \"raw\"\nshutdown-timeout: 2m # wait for the guest\nreadonly: 'true'\n\n"},
+		{name: "command only", config: "volume-format: raw\nforce: true\n", wantErr: true},
+		{name: "pool", config: "pool: default\n", wantErr: true},
+		{name: "network", config: "network: default\n", wantErr: true},
+		{name: "vm", config: "vm: web1\n", wantErr: true},
+		{name: "unknown", config: "no-such-flag: 1\n", wantErr: true},
+		{name: "not a map", config: "connect qemu:///system\n", wantErr: true},
+		{name: "bad value", config: "shutdown-timeout: soon\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".yaml")
+		if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := LoadConfig(path, true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LoadConfig(%v) error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.name == "settings" && err == nil {
+			if *connectUri != "qemu+ssh://root@host/system" || *volumeFormat != "raw" || *shutdownTimeout != 2*time.Minute || !*readonly {
+				t.Errorf("LoadConfig(%v) set connect %q, pool %q, shutdown-timeout %v, readonly %v",
+					tt.name, *connectUri, *storagePool, *shutdownTimeout, *readonly)
+			}
+			if !FlagGiven("volume-format") || FlagGiven("timeout") {
+				t.Errorf("FlagGiven(volume-format) = %v, FlagGiven(timeout) = %v, want true, false", FlagGiven("volume-format"), FlagGiven("timeout"))
+			}
+		}
+		if *force || *storagePool != "" {
+			t.Errorf("LoadConfig(%v) set --force %v or --pool %q", tt.name, *force, *storagePool)
+		}
+		resetConfigFlags(t)
+	}
+}
+
+func TestLoadConfigCommandLineWins(t *testing.T) {
+	defer resetConfigFlags(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("volume-format: raw\nconnect: qemu:///session\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pflag.Set("volume-format", "qcow2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadConfig(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if *volumeFormat != "qcow2" {
+		t.Errorf("volume-format = %q, want the command line value", *volumeFormat)
+	}
+	if *connectUri != "qemu:///session" {
+		t.Errorf("connect = %q, want the config file value", *connectUri)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+
+	if err := LoadConfig(path, false); err != nil {
+		t.Errorf("LoadConfig of a missing default file error = %v, want none", err)
+	}
+	if err := LoadConfig(path, true); err == nil {
+		t.Errorf("LoadConfig of a missing --config file gave no error")
+	}
+}
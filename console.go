@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineScreenshotInfo struct {
+	Path     string
+	MimeType string
+	Bytes    int
+}
+
+// VirtualMachineScreenshot captures the framebuffer of a VM display into a file.
+func VirtualMachineScreenshot(vm string, output string, screen uint) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	stream, err := libvirtInstance.NewStream(0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer stream.Free()
+
+	MimeType, err := d.Screenshot(stream, uint32(screen), 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	file, err := os.Create(output)
+	herr(err)
+	if err != nil {
+		stream.Abort()
+		return
+	}
+	defer file.Close()
+
+	written := 0
+	err = stream.RecvAll(func(s *libvirt.Stream, data []byte) (int, error) {
+		n, err := file.Write(data)
+		written += n
+		return n, err
+	})
+	if err != nil {
+		stream.Abort()
+		herr(err)
+		return
+	}
+
+	err = stream.Finish()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(VirtualMachineScreenshotInfo{Path: output, MimeType: MimeType, Bytes: written})
+}
+
+// VirtualMachineSendKey presses a key combination in a VM, all keys are held down together.
+func VirtualMachineSendKey(vm string, keys string, holdTime uint) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	keycodes, err := ParseKeycodes(keys)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	if len(keycodes) == 0 || len(keycodes) > int(libvirt.DOMAIN_SEND_KEY_MAX_KEYS) {
+		herr(fmt.Errorf("between 1 and %d keys can be sent at once", libvirt.DOMAIN_SEND_KEY_MAX_KEYS))
+		return
+	}
+
+	err = d.SendKey(uint(libvirt.KEYCODE_SET_LINUX), holdTime, keycodes, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("%v was sent to %v", keys, vm))
+}
+
+type VirtualMachineGraphics struct {
+	Type    string
+	Listen  string
+	Port    int
+	TlsPort int
+}
+
+// VirtualMachineGraphicsInfo returns connection info of every VNC/SPICE display of a VM.
+func VirtualMachineGraphicsInfo(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	// the live xml has autoports already resolved into actual ports.
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+
+	ret := []VirtualMachineGraphics{}
+	for _, graphics := range DomXML.Devices.Graphics {
+		info := VirtualMachineGraphics{
+			Type:    graphics.Type,
+			Listen:  graphics.Listen,
+			Port:    graphics.Port,
+			TlsPort: graphics.TlsPort,
+		}
+		for _, listen := range graphics.Listens {
+			if listen.Address != "" {
+				info.Listen = listen.Address
+			} else if listen.Socket != "" {
+				info.Listen = listen.Socket
+			}
+		}
+		ret = append(ret, info)
+	}
+
+	hret(ret)
+}
+
+type VirtualMachineGraphicsPassword struct {
+	PasswordSet bool
+	// ValidTo is empty when the password never expires.
+	ValidTo string
+}
+
+// VirtualMachineSetGraphicsPassword sets or, when password is empty, clears the password of VM displays.
+// A non zero validity makes the password expire after that time.
+func VirtualMachineSetGraphicsPassword(vm string, password string, validity time.Duration) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+
+	if len(DomXML.Devices.Graphics) == 0 {
+		herr(fmt.Errorf("%v has no graphics devices", vm))
+		return
+	}
+
+	ret := VirtualMachineGraphicsPassword{PasswordSet: password != ""}
+	if password != "" && validity > 0 {
+		ret.ValidTo = time.Now().UTC().Add(validity).Format("2006-01-02T15:04:05")
+	}
+
+	for _, graphics := range DomXML.Devices.Graphics {
+		graphics.Passwd = password
+		graphics.PasswdValidTo = ret.ValidTo
+
+		GraphicsXML, err := xml.Marshal(graphics)
+		herr(err)
+
+		err = d.UpdateDeviceFlags(string(GraphicsXML), DeviceModifyFlags())
+		herr(err)
+	}
+
+	hret(ret)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineCpuModel struct {
+	// Mode is host-passthrough, host-model or custom for a named model.
+	Mode  string
+	Model string
+	// Features are changes to the model, +name for required and -name for disabled features.
+	Features      []string
+	RestartNeeded bool
+}
+
+var cpuFeaturePolicies = map[byte]string{'+': "require", '-': "disable"}
+
+// VirtualMachineSetCpuModel sets the cpu a VM sees: host-passthrough, host-model or a named model, e.g. Skylake-Server,
+// with features added or taken away, e.g. +aes or -svm. Topology and numa cells of the VM are kept.
+// A named model is checked against the models the host knows and can run.
+func VirtualMachineSetCpuModel(vm string, model string, features []string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	for _, feature := range features {
+		if len(feature) < 2 || cpuFeaturePolicies[feature[0]] == "" {
+			herr(fmt.Errorf("bad cpu feature %v, use +name to require or -name to disable it, e.g. +aes", feature))
+			return
+		}
+	}
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		NewCpu := NewXMLNode("cpu", "mode", model)
+		if model != "host-passthrough" && model != "host-model" {
+			if err := CheckCpuModel(DomXML, model); err != nil {
+				return err
+			}
+			NewCpu = NewXMLNode("cpu", "mode", "custom", "match", "exact", "check", "partial")
+			CpuModel := NewXMLNode("model", "fallback", "forbid")
+			CpuModel.Text = model
+			NewCpu.Nodes = append(NewCpu.Nodes, CpuModel)
+		}
+
+		OldCpu := DomXML.EnsureChild("cpu")
+		for _, child := range OldCpu.Nodes {
+			if name := child.XMLName.Local; name != "model" && name != "vendor" && name != "feature" {
+				NewCpu.Nodes = append(NewCpu.Nodes, child)
+			}
+		}
+		for _, feature := range features {
+			NewCpu.Nodes = append(NewCpu.Nodes, NewXMLNode("feature", "policy", cpuFeaturePolicies[feature[0]], "name", feature[1:]))
+		}
+
+		*OldCpu = NewCpu
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetCpuModel(d)
+}
+
+// CheckCpuModel makes sure the hypervisor of a vm knows a named cpu model and the host can run it.
+func CheckCpuModel(DomXML *XMLNode, model string) error {
+	var emulator, arch, machine string
+	if devices := DomXML.Child("devices"); devices != nil && devices.Child("emulator") != nil {
+		emulator = devices.Child("emulator").Text
+	}
+	if OsXML := DomXML.Child("os"); OsXML != nil && OsXML.Child("type") != nil {
+		arch, machine = OsXML.Child("type").Attr("arch"), OsXML.Child("type").Attr("machine")
+	}
+	if arch == "" {
+		CapsXML, err := GetVirtualMachineDomainCapabilities(DomXML)
+		if err != nil {
+			return err
+		}
+		arch = CapsXML.Arch
+	}
+
+	models, err := libvirtInstance.GetCPUModelNames(arch, 0)
+	if err != nil {
+		return err
+	}
+	if pickFirst(models, model) == "" {
+		return fmt.Errorf("unknown cpu model %v for %v, use host-passthrough, host-model or one of %v", model, arch, strings.Join(models, ", "))
+	}
+
+	cpu, CpuModel := NewXMLNode("cpu", "mode", "custom", "match", "exact"), NewXMLNode("model")
+	CpuModel.Text = model
+	cpu.Nodes = []XMLNode{CpuModel}
+	desc, err := cpu.String()
+	if err != nil {
+		return err
+	}
+
+	result, err := libvirtInstance.CompareHypervisorCPU(emulator, arch, machine, DomXML.Attr("type"), desc, 0)
+	if err != nil {
+		return err
+	}
+	if result == libvirt.CPU_COMPARE_INCOMPATIBLE {
+		return fmt.Errorf("the host cpu can not run cpu model %v", model)
+	}
+	return nil
+}
+
+// VirtualMachineGetCpuModel returns the cpu a VM sees.
+func VirtualMachineGetCpuModel(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineCpuModel{Features: []string{}, RestartNeeded: RestartNeeded(d)}
+	if cpu := DomXML.Child("cpu"); cpu != nil {
+		ret.Mode = cpu.Attr("mode")
+		if ret.Mode == "" {
+			ret.Mode = "custom"
+		}
+		if model := cpu.Child("model"); model != nil {
+			ret.Model = strings.TrimSpace(model.Text)
+		}
+		for _, feature := range cpu.Children("feature") {
+			switch feature.Attr("policy") {
+			case "require", "force":
+				ret.Features = append(ret.Features, "+"+feature.Attr("name"))
+			case "disable", "forbid":
+				ret.Features = append(ret.Features, "-"+feature.Attr("name"))
+			}
+		}
+	}
+
+	hret(ret)
+}
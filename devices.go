@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// VirtualMachineAttachDevice attaches any device described by an xml fragment file to a VM.
+func VirtualMachineAttachDevice(vm string, deviceXml string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DeviceXML, err := ReadXMLFile(deviceXml)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.AttachDeviceFlags(DeviceXML, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("device from %v was attached to %v", deviceXml, vm))
+}
+
+// VirtualMachineDetachDevice detaches a device described by an xml fragment file from a VM.
+func VirtualMachineDetachDevice(vm string, deviceXml string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DeviceXML, err := ReadXMLFile(deviceXml)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.DetachDeviceFlags(DeviceXML, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("device from %v was detached from %v", deviceXml, vm))
+}
+
+// ReadXMLFile reads an xml file, e.g. a device fragment, and makes sure it is well-formed xml with a single root element.
+func ReadXMLFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err = CheckXML(path, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// CheckXML makes sure data is well formed xml with a single root element.
+func CheckXML(path string, data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	roots := 0
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%v is not a valid xml: %v", path, err)
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	if roots != 1 {
+		return fmt.Errorf("%v must contain exactly one root element, found %d", path, roots)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineMediaInfo struct {
+	TargetDev string
+	Source    string
+}
+
+type VirtualMachineBlockInfo struct {
+	TargetDev       string
+	CapacityBytes   uint64
+	AllocationBytes uint64
+	PhysicalBytes   uint64
+}
+
+// VirtualMachineBlockResize grows (or shrinks) a virtual disk of a VM as seen by the guest.
+func VirtualMachineBlockResize(vm string, targetDev string, size string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	bytes, err := ParseSize(size)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	// libvirt takes KiB unless told otherwise, we always hand it bytes.
+	err = d.BlockResize(targetDev, bytes, libvirt.DOMAIN_BLOCK_RESIZE_BYTES)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(GetVirtualMachineBlockInfo(d, targetDev))
+}
+
+func GetVirtualMachineBlockInfo(d *libvirt.Domain, targetDev string) VirtualMachineBlockInfo {
+	info, err := d.GetBlockInfo(targetDev, 0)
+	herr(err)
+
+	return VirtualMachineBlockInfo{
+		TargetDev:       targetDev,
+		CapacityBytes:   info.Capacity,
+		AllocationBytes: info.Allocation,
+		PhysicalBytes:   info.Physical,
+	}
+}
+
+// VirtualMachineChangeMedia inserts a new media into a removable drive (cdrom, floppy) of a VM.
+// Empty source ejects the current media.
+func VirtualMachineChangeMedia(vm string, targetDev string, source string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DomXML, err := GetDomainXML(d, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	disk := DomXML.FindDisk(targetDev)
+	if disk == nil {
+		herr(fmt.Errorf("%v has no disk %v", vm, targetDev))
+		return
+	}
+	if disk.Device != "cdrom" && disk.Device != "floppy" {
+		herr(fmt.Errorf("%v of %v is a %v, not a removable drive", targetDev, vm, disk.Device))
+		return
+	}
+
+	disk.Type = "file"
+	disk.Source = nil
+	if source != "" {
+		disk.Source = &DomainDiskSourceXML{File: source}
+	}
+
+	DiskXML, err := xml.Marshal(disk)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.UpdateDeviceFlags(string(DiskXML), DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	DomXML, err = GetDomainXML(d, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineMediaInfo{TargetDev: targetDev}
+	if disk = DomXML.FindDisk(targetDev); disk != nil && disk.Source != nil {
+		ret.Source = disk.Source.File
+	}
+	hret(ret)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"libvirt.org/go/libvirt"
+)
+
+// DomainXML is a partial model of the libvirt domain XML. Only the elements we actually read are mapped,
+// everything else is silently ignored by encoding/xml.
+type DomainXML struct {
+	Name    string           `xml:"name"`
+	Devices DomainDevicesXML `xml:"devices"`
+}
+
+type DomainDevicesXML struct {
+	Disks      []DomainDiskXML      `xml:"disk"`
+	Interfaces []DomainInterfaceXML `xml:"interface"`
+	Graphics   []DomainGraphicsXML  `xml:"graphics"`
+	Hostdevs   []DomainHostdevXML   `xml:"hostdev"`
+}
+
+type DomainDiskXML struct {
+	XMLName xml.Name `xml:"disk"`
+	Type    string   `xml:"type,attr"`
+	Device  string   `xml:"device,attr,omitempty"`
+	Driver  *struct {
+		Name string `xml:"name,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+	} `xml:"driver"`
+	Source       *DomainDiskSourceXML   `xml:"source"`
+	BackingStore *DomainBackingStoreXML `xml:"backingStore"`
+	Target       DomainDiskTargetXML    `xml:"target"`
+	ReadOnly     *struct{}              `xml:"readonly"`
+}
+
+// DomainBackingStoreXML is one image of a disk backing chain. An empty element terminates the chain.
+type DomainBackingStoreXML struct {
+	Type         string                 `xml:"type,attr,omitempty"`
+	Index        string                 `xml:"index,attr,omitempty"`
+	Source       *DomainDiskSourceXML   `xml:"source"`
+	BackingStore *DomainBackingStoreXML `xml:"backingStore"`
+}
+
+type DomainDiskSourceXML struct {
+	File   string `xml:"file,attr,omitempty"`
+	Dev    string `xml:"dev,attr,omitempty"`
+	Pool   string `xml:"pool,attr,omitempty"`
+	Volume string `xml:"volume,attr,omitempty"`
+}
+
+type DomainDiskTargetXML struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr,omitempty"`
+}
+
+// FindDisk returns the disk with a given target device name, or nil.
+func (dx *DomainXML) FindDisk(targetDev string) *DomainDiskXML {
+	for i := range dx.Devices.Disks {
+		if dx.Devices.Disks[i].Target.Dev == targetDev {
+			return &dx.Devices.Disks[i]
+		}
+	}
+	return nil
+}
+
+// BackingChain returns paths of the disk images from the top one down to the base.
+func (disk *DomainDiskXML) BackingChain() []string {
+	chain := []string{}
+
+	source, backing := disk.Source, disk.BackingStore
+	for source != nil {
+		switch {
+		case source.File != "":
+			chain = append(chain, source.File)
+		case source.Dev != "":
+			chain = append(chain, source.Dev)
+		default:
+			chain = append(chain, source.Pool+"/"+source.Volume)
+		}
+
+		if backing == nil {
+			break
+		}
+		source, backing = backing.Source, backing.BackingStore
+	}
+
+	return chain
+}
+
+// GoesWithVm reports whether the storage of a disk belongs to the vm alone and is removed along with it,
+// unlike shared media and read only images.
+func (disk *DomainDiskXML) GoesWithVm() bool {
+	return disk.Device != "cdrom" && disk.Device != "floppy" && disk.ReadOnly == nil
+}
+
+// UsesBlockDevice reports whether any disk of the domain is backed by a host block device.
+func (dx *DomainXML) UsesBlockDevice(path string) bool {
+	for _, disk := range dx.Devices.Disks {
+		if disk.Source != nil && disk.Source.Dev == path {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesStorageVolume reports whether any disk of the domain points to a volume, either by path or by pool and volume name.
+// Images further down a backing chain count as used too.
+func (dx *DomainXML) UsesStorageVolume(poolName string, volumeName string, path string) bool {
+	for _, disk := range dx.Devices.Disks {
+		source, backing := disk.Source, disk.BackingStore
+		for source != nil {
+			if path != "" && (source.File == path || source.Dev == path) {
+				return true
+			}
+			if volumeName != "" && source.Pool == poolName && source.Volume == volumeName {
+				return true
+			}
+
+			if backing == nil {
+				break
+			}
+			source, backing = backing.Source, backing.BackingStore
+		}
+	}
+	return false
+}
+
+type DomainInterfaceXML struct {
+	Type string `xml:"type,attr"`
+	Mac  struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Source struct {
+		Network string `xml:"network,attr"`
+		Bridge  string `xml:"bridge,attr"`
+		// Address is set for interfaces of type hostdev, passing a host nic through.
+		Address *PciAddressXML `xml:"address"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"target"`
+}
+
+type DomainHostdevXML struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Address *PciAddressXML `xml:"address"`
+	} `xml:"source"`
+}
+
+type DomainGraphicsXML struct {
+	XMLName  xml.Name `xml:"graphics"`
+	Type     string   `xml:"type,attr"`
+	Port     int      `xml:"port,attr,omitempty"`
+	TlsPort  int      `xml:"tlsPort,attr,omitempty"`
+	AutoPort string   `xml:"autoport,attr,omitempty"`
+	Listen   string   `xml:"listen,attr,omitempty"`
+	Keymap   string   `xml:"keymap,attr,omitempty"`
+	Passwd   string   `xml:"passwd,attr,omitempty"`
+	// PasswdValidTo is a UTC timestamp without a zone, e.g. 2010-04-09T15:51:00
+	PasswdValidTo string `xml:"passwdValidTo,attr,omitempty"`
+	Listens       []struct {
+		Type    string `xml:"type,attr"`
+		Address string `xml:"address,attr,omitempty"`
+		Network string `xml:"network,attr,omitempty"`
+		Socket  string `xml:"socket,attr,omitempty"`
+	} `xml:"listen"`
+}
+
+// GetDomainXML fetches and parses the XML description of a domain.
+func GetDomainXML(d *libvirt.Domain, flags libvirt.DomainXMLFlags) (DomainXML, error) {
+	var DomXML DomainXML
+
+	desc, err := d.GetXMLDesc(flags)
+	if err != nil {
+		return DomXML, err
+	}
+
+	err = xml.Unmarshal([]byte(desc), &DomXML)
+	return DomXML, err
+}
+
+// RedefineDomain edits the persistent xml of a domain and defines it again. A running VM keeps its current
+// configuration until it is restarted. The xml is fetched with secrets, so e.g. a vnc password survives.
+func RedefineDomain(d *libvirt.Domain, edit func(DomXML *XMLNode) error) error {
+	return RedefineDomainFlags(d, 0, edit)
+}
+
+// RedefineDomainFlags is RedefineDomain undefining the domain first when flags are given, e.g. DOMAIN_UNDEFINE_NVRAM
+// to drop state files the new xml can not use. Should the new xml fail to define, the old one is defined again.
+func RedefineDomainFlags(d *libvirt.Domain, undefine libvirt.DomainUndefineFlagsValues, edit func(DomXML *XMLNode) error) error {
+	persistent, err := d.IsPersistent()
+	if err != nil {
+		return err
+	}
+	if !persistent {
+		return errors.New("a transient vm has no configuration to change, it would become persistent")
+	}
+
+	OldDesc, err := d.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE | libvirt.DOMAIN_XML_SECURE)
+	if err != nil {
+		return err
+	}
+
+	DomXML, err := ParseXMLNode(OldDesc)
+	if err != nil {
+		return err
+	}
+	if err = edit(DomXML); err != nil {
+		return err
+	}
+
+	desc, err := DomXML.String()
+	if err != nil {
+		return err
+	}
+
+	if undefine != 0 {
+		if err = d.UndefineFlags(undefine); err != nil {
+			return err
+		}
+	}
+
+	defined, err := libvirtInstance.DomainDefineXMLFlags(desc, libvirt.DOMAIN_DEFINE_VALIDATE)
+	if err != nil {
+		if undefine != 0 {
+			if restored, err := libvirtInstance.DomainDefineXML(OldDesc); err == nil {
+				restored.Free()
+			}
+		}
+		return err
+	}
+	return defined.Free()
+}
+
+// GetDomainXMLNode fetches the XML description of a domain as a node tree.
+func GetDomainXMLNode(d *libvirt.Domain, flags libvirt.DomainXMLFlags) (*XMLNode, error) {
+	desc, err := d.GetXMLDesc(flags)
+	if err != nil {
+		return nil, err
+	}
+	return ParseXMLNode(desc)
+}
+
+// RestartNeeded reports whether a VM runs, so changes to its persistent configuration wait for the next start.
+func RestartNeeded(d *libvirt.Domain) bool {
+	active, err := d.IsActive()
+	return err == nil && active
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+)
+
+// DryRunPlan is what a command would do with --dry-run, nothing is changed to get it.
+type DryRunPlan struct {
+	Action string
+	Vms    []string
+	// Volumes are paths of the storage the command would use or remove.
+	Volumes []string
+	Steps   []string
+}
+
+// VirtualMachineBatchPlan lists the vms a command over several vms would act on.
+func VirtualMachineBatchPlan(action string, vms []string) DryRunPlan {
+	plan := DryRunPlan{Action: action, Vms: vms, Volumes: []string{}, Steps: []string{}}
+	for _, vm := range vms {
+		plan.Steps = append(plan.Steps, action+" "+vm)
+	}
+	return plan
+}
+
+// VirtualMachineDeletePlan is what VirtualMachineDelete would do to a vm, including the volumes it would remove.
+func VirtualMachineDeletePlan(d *libvirt.Domain, vm string, active bool, removeStorage bool, removeNvram bool) DryRunPlan {
+	plan := DryRunPlan{Action: "vm delete", Vms: []string{vm}, Volumes: []string{}}
+
+	if active {
+		plan.Steps = append(plan.Steps, "shut off "+vm)
+	}
+	plan.Steps = append(plan.Steps, "undefine "+vm)
+
+	if removeNvram {
+		plan.Steps = append(plan.Steps, "remove nvram of "+vm)
+	}
+	if managedSave, err := d.HasManagedSaveImage(0); err == nil && managedSave {
+		plan.Steps = append(plan.Steps, "remove managed save image of "+vm)
+	}
+
+	if CloudInit, ok := GetCloudInitIso(d); ok {
+		volume := CloudInit.Pool + "/" + CloudInit.Volume
+		plan.Volumes = append(plan.Volumes, volume)
+		plan.Steps = append(plan.Steps, "delete cloud-init iso volume "+volume)
+	}
+
+	if !removeStorage {
+		return plan
+	}
+
+	DomXML, err := GetDomainXML(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return plan
+	}
+
+	volumes, kept := VirtualMachineOwnVolumes(vm, DomXML.Devices.Disks)
+	for _, path := range volumes {
+		plan.Volumes = append(plan.Volumes, path)
+		plan.Steps = append(plan.Steps, "delete volume "+path)
+	}
+	for _, path := range kept {
+		plan.Steps = append(plan.Steps, "keep volume "+path+", it is still in use")
+	}
+
+	return plan
+}
+
+// VirtualMachineCreatePlan is what VirtualMachineCreate would do with a rendered domain xml.
+func VirtualMachineCreatePlan(DomainXml string, transient bool, start bool) (DryRunPlan, error) {
+	var DomXML DomainXML
+	if err := xml.Unmarshal([]byte(DomainXml), &DomXML); err != nil {
+		return DryRunPlan{}, err
+	}
+
+	plan := DryRunPlan{Action: "vm create", Vms: []string{DomXML.Name}, Volumes: []string{}}
+	for _, disk := range DomXML.Devices.Disks {
+		if disk.Source == nil {
+			continue
+		}
+		switch {
+		case disk.Source.File != "":
+			plan.Volumes = append(plan.Volumes, disk.Source.File)
+		case disk.Source.Dev != "":
+			plan.Volumes = append(plan.Volumes, disk.Source.Dev)
+		case disk.Source.Volume != "":
+			plan.Volumes = append(plan.Volumes, disk.Source.Pool+"/"+disk.Source.Volume)
+		}
+	}
+
+	switch {
+	case transient:
+		plan.Steps = []string{fmt.Sprintf("create and start transient vm %v", DomXML.Name)}
+	case start:
+		plan.Steps = []string{fmt.Sprintf("define vm %v", DomXML.Name), fmt.Sprintf("start %v", DomXML.Name)}
+	default:
+		plan.Steps = []string{fmt.Sprintf("define vm %v", DomXML.Name)}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineFirmware struct {
+	// Firmware is efi or bios.
+	Firmware   string
+	SecureBoot bool
+	BootMenu   bool
+	// Loader is the firmware image, empty while libvirt picks one by itself.
+	Loader        string
+	RestartNeeded bool
+}
+
+// domainFirmware returns the firmware a domain xml boots with, either picked by libvirt or given as a pflash loader.
+func domainFirmware(OsXML *XMLNode) string {
+	if OsXML.Attr("firmware") == "efi" {
+		return "efi"
+	}
+	if loader := OsXML.Child("loader"); loader != nil && loader.Attr("type") == "pflash" {
+		return "efi"
+	}
+	return "bios"
+}
+
+// domainSecureBoot reports whether a domain xml asks for secure boot, by firmware feature or by loader.
+func domainSecureBoot(OsXML *XMLNode) bool {
+	if firmware := OsXML.Child("firmware"); firmware != nil {
+		for _, feature := range firmware.Children("feature") {
+			if feature.Attr("name") == "secure-boot" {
+				return feature.Attr("enabled") == "yes"
+			}
+		}
+	}
+	if loader := OsXML.Child("loader"); loader != nil {
+		return loader.Attr("secure") == "yes"
+	}
+	return false
+}
+
+// CheckFirmwareSupport makes sure the hypervisor of a vm can run the firmware asked for, before the vm is redefined.
+func CheckFirmwareSupport(DomXML *XMLNode, firmware string, secureBoot bool) error {
+	CapsXML, err := GetVirtualMachineDomainCapabilities(DomXML)
+	if err != nil {
+		return err
+	}
+
+	if firmware == "efi" && pickFirst(domainCapsEnum(CapsXML.Os.Enums, "firmware"), "efi") == "" {
+		return errors.New("the host has no efi firmware for this vm, install ovmf or edk2")
+	}
+	if secureBoot && pickFirst(domainCapsEnum(CapsXML.Os.Loader.Enums, "secure"), "yes") == "" {
+		return errors.New("the host has no secure boot firmware for this vm")
+	}
+	return nil
+}
+
+// VirtualMachineSetFirmware switches a VM between efi and bios firmware, and secure boot and the boot menu on or off.
+// Parameters that are empty or nil are left as they are. Changing firmware or secure boot drops the loader and nvram
+// paths, libvirt then picks a firmware image matching the request by itself.
+func VirtualMachineSetFirmware(vm string, firmware string, secureBoot *bool, bootMenu *bool) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if firmware != "" && firmware != "efi" && firmware != "bios" {
+		herr(fmt.Errorf("unknown firmware %v, use efi or bios", firmware))
+		return
+	}
+
+	// vars of the old firmware do not fit the new one, so they are dropped and created anew from its template.
+	var undefine libvirt.DomainUndefineFlagsValues
+	CurrentXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+	CurrentOs := CurrentXML.EnsureChild("os")
+	if (firmware != "" && firmware != domainFirmware(CurrentOs)) ||
+		(secureBoot != nil && *secureBoot != domainSecureBoot(CurrentOs)) {
+		active, err := d.IsActive()
+		if err == nil && active {
+			err = fmt.Errorf("%v has to be shut off to change its firmware, the nvram vars of the old one are removed", vm)
+		}
+		herr(err)
+		if err != nil {
+			return
+		}
+		undefine = libvirt.DOMAIN_UNDEFINE_NVRAM
+	}
+
+	err = RedefineDomainFlags(d, undefine, func(DomXML *XMLNode) error {
+		// features first, adding it may move os.
+		if secureBoot != nil && *secureBoot {
+			// secure boot firmware refuses to run without system management mode.
+			DomXML.EnsureChild("features").EnsureChild("smm").SetAttr("state", "on")
+		}
+		OsXML := DomXML.EnsureChild("os")
+
+		NewFirmware := firmware
+		if NewFirmware == "" {
+			NewFirmware = domainFirmware(OsXML)
+		}
+		NewSecureBoot := domainSecureBoot(OsXML)
+		if secureBoot != nil {
+			NewSecureBoot = *secureBoot
+		}
+		if NewSecureBoot && NewFirmware != "efi" {
+			return errors.New("secure boot needs efi firmware, pass --set-firmware efi too")
+		}
+
+		if firmware != "" || secureBoot != nil {
+			if err := CheckFirmwareSupport(DomXML, NewFirmware, NewSecureBoot); err != nil {
+				return err
+			}
+
+			OsXML.RemoveChildren("loader")
+			OsXML.RemoveChildren("nvram")
+			OsXML.RemoveChildren("firmware")
+			OsXML.SetAttr("firmware", "")
+			if NewFirmware == "efi" {
+				OsXML.SetAttr("firmware", "efi")
+				features := NewXMLNode("firmware")
+				features.Nodes = []XMLNode{
+					NewXMLNode("feature", "enabled", yesNo(NewSecureBoot), "name", "secure-boot"),
+					NewXMLNode("feature", "enabled", yesNo(NewSecureBoot), "name", "enrolled-keys"),
+				}
+				OsXML.Nodes = append(OsXML.Nodes, features)
+			}
+		}
+
+		if bootMenu != nil {
+			OsXML.EnsureChild("bootmenu").SetAttr("enable", yesNo(*bootMenu))
+		}
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetFirmware(d)
+}
+
+// VirtualMachineGetFirmware returns the firmware config of a VM.
+func VirtualMachineGetFirmware(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	OsXML := DomXML.EnsureChild("os")
+	ret := VirtualMachineFirmware{
+		Firmware:      domainFirmware(OsXML),
+		SecureBoot:    domainSecureBoot(OsXML),
+		RestartNeeded: RestartNeeded(d),
+	}
+	if bootmenu := OsXML.Child("bootmenu"); bootmenu != nil {
+		ret.BootMenu = bootmenu.Attr("enable") == "yes"
+	}
+	if loader := OsXML.Child("loader"); loader != nil {
+		ret.Loader = loader.Text
+	}
+
+	hret(ret)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
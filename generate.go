@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// GeneratedDomainXML is the minimal domain xml --generate-template writes.
+type GeneratedDomainXML struct {
+	XMLName xml.Name `xml:"domain"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name"`
+	Memory  struct {
+		Unit  string `xml:"unit,attr"`
+		Value uint64 `xml:",chardata"`
+	} `xml:"memory"`
+	Vcpu uint `xml:"vcpu"`
+	Os   struct {
+		Firmware string `xml:"firmware,attr,omitempty"`
+		Type     struct {
+			Arch    string `xml:"arch,attr,omitempty"`
+			Machine string `xml:"machine,attr,omitempty"`
+			Value   string `xml:",chardata"`
+		} `xml:"type"`
+	} `xml:"os"`
+	Features *GeneratedFeaturesXML `xml:"features"`
+	Cpu      *GeneratedCpuXML      `xml:"cpu"`
+	Clock    struct {
+		Offset string `xml:"offset,attr"`
+	} `xml:"clock"`
+	Devices GeneratedDevicesXML `xml:"devices"`
+}
+
+type GeneratedDevicesXML struct {
+	Emulator   string                  `xml:"emulator,omitempty"`
+	Disks      []DomainDiskXML         `xml:"disk"`
+	Interfaces []GeneratedInterfaceXML `xml:"interface"`
+	Console    struct {
+		Type string `xml:"type,attr"`
+	} `xml:"console"`
+	// Channel connects the guest agent, which many commands of the helper need.
+	Channel struct {
+		Type   string `xml:"type,attr"`
+		Target struct {
+			Type string `xml:"type,attr"`
+			Name string `xml:"name,attr"`
+		} `xml:"target"`
+	} `xml:"channel"`
+	Graphics *DomainGraphicsXML `xml:"graphics"`
+	Video    *GeneratedVideoXML `xml:"video"`
+}
+
+type GeneratedFeaturesXML struct {
+	Acpi struct{} `xml:"acpi"`
+	Apic struct{} `xml:"apic"`
+}
+
+type GeneratedCpuXML struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type GeneratedInterfaceXML struct {
+	Type   string `xml:"type,attr"`
+	Source struct {
+		Network string `xml:"network,attr"`
+	} `xml:"source"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+type GeneratedVideoXML struct {
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+// pickFirst returns the first of the preferred values the host supports, or empty.
+func pickFirst(supported []string, preferred ...string) string {
+	for _, value := range preferred {
+		for _, s := range supported {
+			if s == value {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// GenerateTemplate prints a minimal domain xml to feed into --create. Emulator, machine, firmware, disk bus and so on
+// come from the domain capabilities of the host. Windows os variants, e.g. win11, get sata and e1000e instead of virtio,
+// since the installer has no virtio drivers. Without a network the vm is attached to the libvirt default one.
+func GenerateTemplate(name string, memory string, vcpus uint, diskPath string, network string, osVariant string) {
+	MemoryBytes, err := ParseSize(memory)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	_, CapsXML, err := GetDomainCapabilities("", "", "", "")
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	windows := strings.HasPrefix(strings.ToLower(osVariant), "win")
+	DiskBuses := domainCapsEnum(CapsXML.Devices.Disk.Enums, "bus")
+
+	var DomXML GeneratedDomainXML
+	DomXML.Type = CapsXML.Domain
+	DomXML.Name = name
+	DomXML.Memory.Unit = "bytes"
+	DomXML.Memory.Value = MemoryBytes
+	DomXML.Vcpu = vcpus
+	DomXML.Os.Type.Arch = CapsXML.Arch
+	DomXML.Os.Type.Machine = CapsXML.Machine
+	DomXML.Os.Type.Value = "hvm"
+	DomXML.Os.Firmware = pickFirst(domainCapsEnum(CapsXML.Os.Enums, "firmware"), "efi")
+	if CapsXML.Arch == "x86_64" || CapsXML.Arch == "i686" {
+		DomXML.Features = &GeneratedFeaturesXML{}
+	}
+	if CapsXML.Domain == "kvm" {
+		DomXML.Cpu = &GeneratedCpuXML{Mode: "host-passthrough"}
+	}
+	DomXML.Clock.Offset = "utc"
+	if windows {
+		DomXML.Clock.Offset = "localtime"
+	}
+
+	DomXML.Devices.Emulator = CapsXML.Path
+
+	if diskPath != "" {
+		disk := DomainDiskXML{Type: "file", Device: "disk", Source: &DomainDiskSourceXML{File: diskPath}}
+		if strings.HasPrefix(diskPath, "/dev/") {
+			disk.Type = "block"
+			disk.Source = &DomainDiskSourceXML{Dev: diskPath}
+		}
+
+		disk.Target = DomainDiskTargetXML{Dev: "vda", Bus: pickFirst(DiskBuses, "virtio", "sata", "ide")}
+		if windows || disk.Target.Bus != "virtio" {
+			disk.Target = DomainDiskTargetXML{Dev: "sda", Bus: pickFirst(DiskBuses, "sata", "ide", "scsi")}
+		}
+
+		DiskFormat := "raw"
+		if filepath.Ext(diskPath) == ".qcow2" {
+			DiskFormat = "qcow2"
+		}
+		disk.Driver = &struct {
+			Name string `xml:"name,attr,omitempty"`
+			Type string `xml:"type,attr,omitempty"`
+		}{Name: "qemu", Type: DiskFormat}
+
+		DomXML.Devices.Disks = append(DomXML.Devices.Disks, disk)
+	}
+
+	if network == "" {
+		network = "default"
+	}
+	iface := GeneratedInterfaceXML{Type: "network"}
+	iface.Source.Network = network
+	iface.Model.Type = "virtio"
+	if windows {
+		iface.Model.Type = "e1000e"
+	}
+	DomXML.Devices.Interfaces = []GeneratedInterfaceXML{iface}
+
+	DomXML.Devices.Console.Type = "pty"
+	DomXML.Devices.Channel.Type = "unix"
+	DomXML.Devices.Channel.Target.Type = "virtio"
+	DomXML.Devices.Channel.Target.Name = "org.qemu.guest_agent.0"
+
+	if GraphicsType := pickFirst(domainCapsEnum(CapsXML.Devices.Graphics.Enums, "type"), "vnc", "spice"); GraphicsType != "" {
+		DomXML.Devices.Graphics = &DomainGraphicsXML{Type: GraphicsType, AutoPort: "yes", Listen: "127.0.0.1"}
+	}
+	if VideoModel := pickFirst(domainCapsEnum(CapsXML.Devices.Video.Enums, "modelType"), "virtio", "qxl", "vga"); VideoModel != "" {
+		DomXML.Devices.Video = &GeneratedVideoXML{}
+		DomXML.Devices.Video.Model.Type = VideoModel
+	}
+
+	desc, err := xml.MarshalIndent(DomXML, "", "  ")
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	fmt.Println(string(desc))
+	hexit(0)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type GuestInfo struct {
+	Os          *GuestOsInfo
+	Hostname    string
+	Timezone    *GuestTimezone
+	Users       []GuestUser
+	FileSystems []GuestFileSystem
+	// Unavailable lists sections the guest agent could not provide.
+	Unavailable []string
+}
+
+type GuestOsInfo struct {
+	Id            string
+	Name          string
+	PrettyName    string
+	Version       string
+	KernelRelease string
+	KernelVersion string
+	Machine       string
+}
+
+type GuestTimezone struct {
+	Name          string
+	OffsetSeconds int
+}
+
+type GuestUser struct {
+	Name        string
+	Domain      string
+	LoginTimeMs uint64
+}
+
+type GuestFileSystem struct {
+	MountPoint string
+	Name       string
+	Type       string
+	TotalBytes uint64
+	UsedBytes  uint64
+	Disks      []string
+}
+
+// VirtualMachineGuestInfo returns os, hostname, timezone, users and filesystems of a guest, as reported by the guest agent.
+// Every section is asked for separately, so a section the agent does not support does not hide the others.
+func VirtualMachineGuestInfo(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	ret := GuestInfo{Unavailable: []string{}}
+
+	if info, err := d.GetGuestInfo(libvirt.DOMAIN_GUEST_INFO_OS, 0); err == nil && info.OS != nil {
+		ret.Os = &GuestOsInfo{
+			Id:            info.OS.ID,
+			Name:          info.OS.Name,
+			PrettyName:    info.OS.PrettyName,
+			Version:       info.OS.Version,
+			KernelRelease: info.OS.KernelRelease,
+			KernelVersion: info.OS.KernelVersion,
+			Machine:       info.OS.Machine,
+		}
+	} else {
+		ret.Unavailable = append(ret.Unavailable, "os")
+	}
+
+	if info, err := d.GetGuestInfo(libvirt.DOMAIN_GUEST_INFO_HOSTNAME, 0); err == nil && info.HostnameSet {
+		ret.Hostname = info.Hostname
+	} else {
+		ret.Unavailable = append(ret.Unavailable, "hostname")
+	}
+
+	if info, err := d.GetGuestInfo(libvirt.DOMAIN_GUEST_INFO_TIMEZONE, 0); err == nil && info.TimeZone != nil {
+		ret.Timezone = &GuestTimezone{Name: info.TimeZone.Name, OffsetSeconds: info.TimeZone.Offset}
+	} else {
+		ret.Unavailable = append(ret.Unavailable, "timezone")
+	}
+
+	if info, err := d.GetGuestInfo(libvirt.DOMAIN_GUEST_INFO_USERS, 0); err == nil {
+		ret.Users = []GuestUser{}
+		for _, user := range info.Users {
+			ret.Users = append(ret.Users, GuestUser{Name: user.Name, Domain: user.Domain, LoginTimeMs: user.LoginTime})
+		}
+	} else {
+		ret.Unavailable = append(ret.Unavailable, "users")
+	}
+
+	if info, err := d.GetGuestInfo(libvirt.DOMAIN_GUEST_INFO_FILESYSTEM, 0); err == nil {
+		ret.FileSystems = []GuestFileSystem{}
+		for _, fs := range info.FileSystems {
+			GuestFs := GuestFileSystem{
+				MountPoint: fs.MountPoint,
+				Name:       fs.Name,
+				Type:       fs.FSType,
+				TotalBytes: fs.TotalBytes,
+				UsedBytes:  fs.UsedBytes,
+				Disks:      []string{},
+			}
+			for _, disk := range fs.Disks {
+				GuestFs.Disks = append(GuestFs.Disks, disk.Alias)
+			}
+			ret.FileSystems = append(ret.FileSystems, GuestFs)
+		}
+	} else {
+		ret.Unavailable = append(ret.Unavailable, "filesystems")
+	}
+
+	hret(ret)
+}
+
+type GuestHostname struct {
+	Hostname string
+}
+
+// VirtualMachineGuestHostname returns the hostname of a guest, a quick check that the right image booted.
+func VirtualMachineGuestHostname(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	hostname, err := d.GetHostname(libvirt.DOMAIN_GET_HOSTNAME_AGENT)
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	hret(GuestHostname{Hostname: hostname})
+}
+
+type GuestAgentPing struct {
+	Alive       bool
+	RoundTripMs int64
+	Error       string
+}
+
+// VirtualMachineAgentPing checks whether the guest agent of a VM responds, and how fast.
+// A zero timeout keeps the libvirt default. The timeout only applies to this ping, rounded up to whole seconds,
+// the response timeout of the domain is left alone.
+func VirtualMachineAgentPing(vm string, timeout time.Duration) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	AgentTimeout := libvirt.DOMAIN_QEMU_AGENT_COMMAND_DEFAULT
+	if timeout > 0 {
+		// libvirt counts whole seconds and takes 0 as do not wait, so anything shorter waits a second.
+		AgentTimeout = libvirt.DomainQemuAgentCommandTimeout((timeout + time.Second - 1) / time.Second)
+	}
+
+	start := time.Now()
+	_, err = d.QemuAgentCommand(`{"execute":"guest-ping"}`, AgentTimeout, 0)
+
+	ret := GuestAgentPing{Alive: err == nil, RoundTripMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		ret.Error = err.Error()
+	}
+
+	hret(ret)
+}
+
+type GuestFsFreeze struct {
+	// Count is the number of filesystems frozen or thawed.
+	Count       int
+	MountPoints []string
+}
+
+// GuestFileSystemMountPoints returns mountpoints the guest agent knows about, or just the given ones when not empty.
+// The agent freezes the same filesystems it lists, so this is also what a freeze without mountpoints covers.
+// libvirt returns the count itself, but the go bindings drop it.
+func GuestFileSystemMountPoints(d *libvirt.Domain, mounts []string) ([]string, error) {
+	if len(mounts) > 0 {
+		return mounts, nil
+	}
+
+	FsInfo, err := d.GetFSInfo(0)
+	if err != nil {
+		return nil, err
+	}
+
+	MountPoints := []string{}
+	for _, fs := range FsInfo {
+		MountPoints = append(MountPoints, fs.MountPoint)
+	}
+	return MountPoints, nil
+}
+
+// VirtualMachineFSFreeze freezes guest filesystems, all of them or only given mountpoints, so a snapshot of a running vm is consistent.
+func VirtualMachineFSFreeze(vm string, mounts []string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	// the agent refuses to list filesystems while they are frozen, so they are counted before.
+	MountPoints, err := GuestFileSystemMountPoints(d, mounts)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.FSFreeze(mounts, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(GuestFsFreeze{Count: len(MountPoints), MountPoints: MountPoints})
+}
+
+// VirtualMachineFSThaw thaws guest filesystems frozen by VirtualMachineFSFreeze.
+func VirtualMachineFSThaw(vm string, mounts []string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = d.FSThaw(mounts, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	MountPoints, err := GuestFileSystemMountPoints(d, mounts)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(GuestFsFreeze{Count: len(MountPoints), MountPoints: MountPoints})
+}
+
+type GuestFsTrim struct {
+	MountPoint string
+	Trimmed    bool
+	Error      string
+}
+
+// VirtualMachineFSTrim discards unused blocks of guest filesystems, giving the space back to thin provisioned disks.
+// Extents smaller than minimum may be skipped by the guest. libvirt does not pass the per mountpoint result of the agent
+// through, so mountpoints are trimmed one by one when given, and all at once with a single result otherwise.
+func VirtualMachineFSTrim(vm string, mounts []string, minimum string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var MinimumBytes uint64
+	if minimum != "" {
+		MinimumBytes, err = ParseSize(minimum)
+		herr(err)
+		if err != nil {
+			return
+		}
+	}
+
+	if len(mounts) == 0 {
+		err = d.FSTrim("", MinimumBytes, 0)
+		herr(err)
+		if err != nil {
+			return
+		}
+		hok("guest filesystems trimmed")
+	}
+
+	ret := []GuestFsTrim{}
+	for _, mount := range mounts {
+		result := GuestFsTrim{MountPoint: mount, Trimmed: true}
+		if err := d.FSTrim(mount, MinimumBytes, 0); err != nil {
+			result.Trimmed = false
+			result.Error = err.Error()
+		}
+		ret = append(ret, result)
+	}
+
+	hret(ret)
+}
+
+type GuestFsMount struct {
+	MountPoint string
+	Name       string
+	Type       string
+	// Disks are target device names of the vm disks backing the filesystem, e.g. vda.
+	Disks []string
+}
+
+// AgentError makes a missing or silent guest agent explain itself, other errors are returned as is.
+func AgentError(err error) error {
+	if IsLibvirtError(err, libvirt.ERR_ARGUMENT_UNSUPPORTED) || IsLibvirtError(err, libvirt.ERR_AGENT_UNRESPONSIVE) {
+		return fmt.Errorf("guest agent is not available, make sure the vm has a guest agent channel and qemu-guest-agent runs inside: %v", err)
+	}
+	return err
+}
+
+// VirtualMachineFSInfo returns mounted guest filesystems together with the vm disks they live on.
+func VirtualMachineFSInfo(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	FsInfo, err := d.GetFSInfo(0)
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	ret := []GuestFsMount{}
+	for _, fs := range FsInfo {
+		ret = append(ret, GuestFsMount{MountPoint: fs.MountPoint, Name: fs.Name, Type: fs.FSType, Disks: append([]string{}, fs.DevAlias...)})
+	}
+
+	var rows [][]string
+	for _, fs := range ret {
+		rows = append(rows, []string{fs.MountPoint, fs.Name, fs.Type, strings.Join(fs.Disks, ",")})
+	}
+	htable(ret, []string{"MOUNTPOINT", "NAME", "TYPE", "DISKS"}, rows)
+}
+
+type GuestTime struct {
+	Seconds     int64
+	Nanoseconds uint
+	Time        string
+}
+
+// VirtualMachineSetTime sets the guest clock, either to a given unix time or by syncing it with the vm rtc,
+// which follows the host clock. Handy after a restore, when the guest clock is far behind.
+func VirtualMachineSetTime(vm string, sync bool, epoch *int64) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	switch {
+	case sync:
+		err = d.SetTime(0, 0, libvirt.DOMAIN_TIME_SYNC)
+	case epoch != nil:
+		err = d.SetTime(*epoch, 0, 0)
+	default:
+		err = fmt.Errorf("--set-time requires either --sync or --epoch")
+	}
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	secs, nsecs, err := d.GetTime(0)
+	herr(AgentError(err))
+
+	hret(GuestTime{Seconds: secs, Nanoseconds: nsecs, Time: time.Unix(secs, int64(nsecs)).UTC().Format(time.RFC3339)})
+}
+
+type GuestSSHKeys struct {
+	User string
+	// Set is the number of keys given, Total the number of keys the user has afterwards.
+	Set      int
+	Total    int
+	Appended bool
+}
+
+// ReadSSHKeys reads public keys from files, one key per line. Blank lines and comments are skipped.
+func ReadSSHKeys(files []string) ([]string, error) {
+	keys := []string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no ssh keys found in %v", strings.Join(files, ", "))
+	}
+	return keys, nil
+}
+
+// VirtualMachineSetUserSSHKeys sets the authorized ssh keys of a guest user through the guest agent, so a running vm
+// gets new keys without rebuilding its cloud-init iso. The keys replace those the user has, unless appended.
+func VirtualMachineSetUserSSHKeys(vm string, user string, files []string, appendKeys bool) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	keys, err := ReadSSHKeys(files)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	var flags libvirt.DomainAuthorizedSSHKeysFlags
+	if appendKeys {
+		flags = libvirt.DOMAIN_AUTHORIZED_SSH_KEYS_SET_APPEND
+	}
+
+	err = d.AuthorizedSSHKeysSet(user, keys, flags)
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	ret := GuestSSHKeys{User: user, Set: len(keys), Total: len(keys), Appended: appendKeys}
+	if AllKeys, err := d.AuthorizedSSHKeysGet(user, 0); err == nil {
+		ret.Total = len(AllKeys)
+	}
+
+	hret(ret)
+}
+
+type GuestExec struct {
+	Pid      int
+	ExitCode int
+	// Signal is the signal that killed the program, 0 when it exited by itself.
+	Signal int
+	Stdout string
+	Stderr string
+	// Truncated is set when the agent cut the output short, it keeps a few megabytes at most.
+	Truncated bool
+}
+
+// guestExecStatus is the reply of the guest-exec-status agent command.
+type guestExecStatus struct {
+	Return struct {
+		Exited       bool   `json:"exited"`
+		ExitCode     int    `json:"exitcode"`
+		Signal       int    `json:"signal"`
+		OutData      []byte `json:"out-data"`
+		ErrData      []byte `json:"err-data"`
+		OutTruncated bool   `json:"out-truncated"`
+		ErrTruncated bool   `json:"err-truncated"`
+	} `json:"return"`
+}
+
+// VirtualMachineGuestExec runs a program in the guest through the guest agent and waits until it exits, or --timeout
+// elapses, in which case the program is left running. Output is captured, there is no shell, so cmd is a program path.
+func VirtualMachineGuestExec(ctx context.Context, vm string, cmd string, args []string) {
+	const maxBackoff = time.Second
+
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	request, err := json.Marshal(map[string]any{
+		"execute":   "guest-exec",
+		"arguments": map[string]any{"path": cmd, "arg": append([]string{}, args...), "capture-output": true},
+	})
+	herr(err)
+
+	reply, err := d.QemuAgentCommand(string(request), libvirt.DOMAIN_QEMU_AGENT_COMMAND_DEFAULT, 0)
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	var exec struct {
+		Return struct {
+			Pid int `json:"pid"`
+		} `json:"return"`
+	}
+	err = json.Unmarshal([]byte(reply), &exec)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	request, _ = json.Marshal(map[string]any{"execute": "guest-exec-status", "arguments": map[string]any{"pid": exec.Return.Pid}})
+
+	backoff := 100 * time.Millisecond
+	for {
+		reply, err = d.QemuAgentCommand(string(request), libvirt.DOMAIN_QEMU_AGENT_COMMAND_DEFAULT, 0)
+		herr(AgentError(err))
+		if err != nil {
+			return
+		}
+
+		var status guestExecStatus
+		err = json.Unmarshal([]byte(reply), &status)
+		herr(err)
+		if err != nil {
+			return
+		}
+
+		if status.Return.Exited {
+			hret(GuestExec{
+				Pid:       exec.Return.Pid,
+				ExitCode:  status.Return.ExitCode,
+				Signal:    status.Return.Signal,
+				Stdout:    string(status.Return.OutData),
+				Stderr:    string(status.Return.ErrData),
+				Truncated: status.Return.OutTruncated || status.Return.ErrTruncated,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			htimeout(fmt.Errorf("%v did not exit within --timeout %v, it still runs in the guest as pid %v", cmd, *timeout, exec.Return.Pid))
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineTpm struct {
+	Model string
+	// Backend is emulator for a swtpm backed tpm, passthrough for a host tpm.
+	Backend       string
+	Version       string
+	RestartNeeded bool
+}
+
+// VirtualMachineAddTpm gives a VM an emulated tpm, backed by swtpm on the host, replacing the tpm it had.
+// Without a model libvirt picks the one the host supports, tpm-crb preferred. A tpm can not be hotplugged.
+func VirtualMachineAddTpm(vm string, version string, model string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if version != "1.2" && version != "2.0" {
+		herr(fmt.Errorf("unknown tpm version %v, use 1.2 or 2.0", version))
+		return
+	}
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		CapsXML, err := GetVirtualMachineDomainCapabilities(DomXML)
+		if err != nil {
+			return err
+		}
+
+		if pickFirst(domainCapsEnum(CapsXML.Devices.Tpm.Enums, "backendModel"), "emulator") == "" {
+			return fmt.Errorf("the host can not emulate a tpm, install swtpm")
+		}
+		// older libvirt does not list versions.
+		if versions := domainCapsEnum(CapsXML.Devices.Tpm.Enums, "backendVersion"); len(versions) > 0 && pickFirst(versions, version) == "" {
+			return fmt.Errorf("the host emulates no tpm %v, only %v", version, versions)
+		}
+
+		models := domainCapsEnum(CapsXML.Devices.Tpm.Enums, "model")
+		if model == "" {
+			model = pickFirst(models, "tpm-crb", "tpm-tis")
+			if version == "1.2" {
+				model = pickFirst(models, "tpm-tis")
+			}
+		}
+		if pickFirst(models, model) == "" {
+			return fmt.Errorf("the host has no tpm model %v, use one of %v", model, models)
+		}
+		if model == "tpm-crb" && version == "1.2" {
+			return fmt.Errorf("tpm-crb only comes in version 2.0, use tpm-tis for 1.2")
+		}
+
+		devices := DomXML.EnsureChild("devices")
+		devices.RemoveChildren("tpm")
+		tpm := NewXMLNode("tpm", "model", model)
+		tpm.Nodes = []XMLNode{NewXMLNode("backend", "type", "emulator", "version", version)}
+		devices.Nodes = append(devices.Nodes, tpm)
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetTpm(d)
+}
+
+// VirtualMachineRemoveTpm takes the tpm away from a VM. The swtpm state on the host is kept.
+func VirtualMachineRemoveTpm(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		if DomXML.EnsureChild("devices").RemoveChildren("tpm") == 0 {
+			return fmt.Errorf("%v has no tpm", vm)
+		}
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("tpm was removed from %v", vm))
+}
+
+// VirtualMachineGetTpm returns the tpm config of a VM.
+func VirtualMachineGetTpm(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	tpm := DomXML.EnsureChild("devices").Child("tpm")
+	if tpm == nil {
+		herr(fmt.Errorf("vm has no tpm"))
+		return
+	}
+
+	ret := VirtualMachineTpm{Model: tpm.Attr("model"), RestartNeeded: RestartNeeded(d)}
+	if backend := tpm.Child("backend"); backend != nil {
+		ret.Backend, ret.Version = backend.Attr("type"), backend.Attr("version")
+	}
+
+	hret(ret)
+}
+
+type VirtualMachineWatchdog struct {
+	Model  string
+	Action string
+}
+
+var watchdogModels = []string{"i6300esb", "itco", "ib700", "diag288"}
+var watchdogActions = []string{"reset", "poweroff", "shutdown", "pause", "dump", "inject-nmi", "none"}
+
+// VirtualMachineSetWatchdog adds a watchdog to a VM, so the hypervisor recovers a hung guest, or changes the action of
+// the watchdog it has. Running VMs are changed on the fly where the hypervisor can, see --live and --persistent.
+func VirtualMachineSetWatchdog(vm string, model string, action string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if pickFirst(watchdogActions, action) == "" {
+		herr(fmt.Errorf("unknown watchdog action %v, use one of %v", action, watchdogActions))
+		return
+	}
+	if model != "" && pickFirst(watchdogModels, model) == "" {
+		herr(fmt.Errorf("unknown watchdog model %v, use one of %v", model, watchdogModels))
+		return
+	}
+
+	scopes, err := DeviceScopes(d)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	// the running and the persistent state may have different watchdogs, each is changed from what it has.
+	for _, scope := range scopes {
+		if err = SetWatchdog(d, scope, model, action); err != nil {
+			herr(err)
+			return
+		}
+	}
+
+	VirtualMachineGetWatchdog(d)
+}
+
+// SetWatchdog changes the watchdog of one state of a domain, or adds one. libvirt only updates the action in place,
+// a watchdog of another model is detached and the new one attached instead.
+func SetWatchdog(d *libvirt.Domain, scope DeviceScope, model string, action string) error {
+	DomXML, err := GetDomainXMLNode(d, scope.XML)
+	if err != nil {
+		return err
+	}
+
+	watchdog := DomXML.EnsureChild("devices").Child("watchdog")
+	if model == "" {
+		model = "i6300esb"
+		if watchdog != nil {
+			model = watchdog.Attr("model")
+		}
+	}
+
+	NewWatchdog := NewXMLNode("watchdog", "model", model, "action", action)
+	desc, err := NewWatchdog.String()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case watchdog == nil:
+		return d.AttachDeviceFlags(desc, scope.Modify)
+	case watchdog.Attr("model") == model:
+		return d.UpdateDeviceFlags(desc, scope.Modify)
+	}
+
+	OldDesc, err := watchdog.String()
+	if err != nil {
+		return err
+	}
+	if err = d.DetachDeviceFlags(OldDesc, scope.Modify); err != nil {
+		return fmt.Errorf("changing the watchdog model to %v needs the %v watchdog detached first: %v", model, watchdog.Attr("model"), err)
+	}
+	return d.AttachDeviceFlags(desc, scope.Modify)
+}
+
+// VirtualMachineGetWatchdog returns the watchdog config of a VM.
+func VirtualMachineGetWatchdog(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, QueryXMLFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	watchdog := DomXML.EnsureChild("devices").Child("watchdog")
+	if watchdog == nil {
+		herr(fmt.Errorf("vm has no watchdog"))
+		return
+	}
+
+	hret(VirtualMachineWatchdog{Model: watchdog.Attr("model"), Action: watchdog.Attr("action")})
+}
+
+type VirtualMachineRng struct {
+	Model   string
+	Backend string
+	// RateBytes is how much entropy the guest gets per RatePeriodMs, 0 is unlimited.
+	RateBytes    uint64
+	RatePeriodMs uint64
+}
+
+// VirtualMachineAddRng gives a VM a virtio entropy source fed from a host device, so crypto in the guest does not
+// stall waiting for entropy. A running VM gets it on the fly, see --live and --persistent.
+func VirtualMachineAddRng(vm string, backend string, rateBytes uint64, ratePeriod time.Duration) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DomXML, err := GetDomainXMLNode(d, QueryXMLFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	CapsXML, err := GetVirtualMachineDomainCapabilities(DomXML)
+	herr(err)
+	if err != nil {
+		return
+	}
+	if pickFirst(domainCapsEnum(CapsXML.Devices.Rng.Enums, "model"), "virtio") == "" ||
+		pickFirst(domainCapsEnum(CapsXML.Devices.Rng.Enums, "backendModel"), "random") == "" {
+		herr(fmt.Errorf("the host has no virtio entropy source fed from a device"))
+		return
+	}
+
+	rng := NewXMLNode("rng", "model", "virtio")
+	if rateBytes > 0 {
+		rng.Nodes = append(rng.Nodes, NewXMLNode("rate", "bytes", strconv.FormatUint(rateBytes, 10), "period", strconv.FormatInt(ratePeriod.Milliseconds(), 10)))
+	}
+	RngBackend := NewXMLNode("backend", "model", "random")
+	RngBackend.Text = backend
+	rng.Nodes = append(rng.Nodes, RngBackend)
+
+	desc, err := rng.String()
+	herr(err)
+
+	err = d.AttachDeviceFlags(desc, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetRngs(d)
+}
+
+// VirtualMachineGetRngs returns the entropy sources of a VM.
+func VirtualMachineGetRngs(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, QueryXMLFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := []VirtualMachineRng{}
+	for _, rng := range DomXML.EnsureChild("devices").Children("rng") {
+		Rng := VirtualMachineRng{Model: rng.Attr("model")}
+		if backend := rng.Child("backend"); backend != nil {
+			Rng.Backend = backend.Text
+			// an egd backend talks to a daemon rather than reading a device.
+			if backend.Attr("model") != "random" {
+				Rng.Backend = backend.Attr("model")
+			}
+		}
+		if rate := rng.Child("rate"); rate != nil {
+			Rng.RateBytes, _ = strconv.ParseUint(rate.Attr("bytes"), 10, 64)
+			Rng.RatePeriodMs, _ = strconv.ParseUint(rate.Attr("period"), 10, 64)
+		}
+		ret = append(ret, Rng)
+	}
+
+	hret(ret)
+}
+
+type VirtualMachineVideo struct {
+	Model         string
+	VramBytes     uint64
+	Heads         int
+	RestartNeeded bool
+}
+
+// VirtualMachineSetVideo changes the model and video memory of the primary video device of a VM, none leaves the VM
+// without one. Other video devices are kept, except with none. Settings of one model, e.g. qxl ram, are dropped on
+// switching to another, as libvirt would refuse them.
+func VirtualMachineSetVideo(vm string, model string, vram string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var VramKiB uint64
+	if vram != "" {
+		VramBytes, err := ParseSize(vram)
+		herr(err)
+		if err != nil {
+			return
+		}
+		VramKiB = VramBytes / 1024
+	}
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		if model != "none" {
+			CapsXML, err := GetVirtualMachineDomainCapabilities(DomXML)
+			if err != nil {
+				return err
+			}
+			if models := domainCapsEnum(CapsXML.Devices.Video.Enums, "modelType"); pickFirst(models, model) == "" {
+				return fmt.Errorf("the host has no video model %v, use one of %v", model, models)
+			}
+		}
+
+		devices := DomXML.EnsureChild("devices")
+		if model == "none" {
+			devices.RemoveChildren("video")
+		}
+		video := devices.Child("video")
+		if video == nil {
+			devices.Nodes = append(devices.Nodes, NewXMLNode("video"))
+			video = devices.Child("video")
+		}
+
+		OldModel := video.EnsureChild("model")
+		NewModel := NewXMLNode("model", "type", model)
+		if model != "none" {
+			if heads := OldModel.Attr("heads"); heads != "" {
+				NewModel.SetAttr("heads", heads)
+			}
+			if primary := OldModel.Attr("primary"); primary != "" {
+				NewModel.SetAttr("primary", primary)
+			}
+			if VramKiB > 0 {
+				NewModel.SetAttr("vram", strconv.FormatUint(VramKiB, 10))
+			} else if OldModel.Attr("type") == model {
+				NewModel.SetAttr("vram", OldModel.Attr("vram"))
+			}
+		}
+		*OldModel = NewModel
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetVideo(d)
+}
+
+// VirtualMachineGetVideo returns the primary video device of a VM.
+func VirtualMachineGetVideo(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineVideo{Model: "none", RestartNeeded: RestartNeeded(d)}
+	if video := DomXML.EnsureChild("devices").Child("video"); video != nil && video.Child("model") != nil {
+		model := video.Child("model")
+		ret.Model = model.Attr("type")
+		if VramKiB, err := strconv.ParseUint(model.Attr("vram"), 10, 64); err == nil {
+			ret.VramBytes = VramKiB * 1024
+		}
+		ret.Heads, _ = strconv.Atoi(model.Attr("heads"))
+	}
+
+	hret(ret)
+}
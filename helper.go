@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/pflag"
 	"libvirt.org/go/libvirt"
@@ -25,20 +30,145 @@ const (
 )
 
 type VirtualMachineStateInfo struct {
+	Uuid           string
+	Id             int // -1 when VM is not running
 	State          VirtualMachineStatus
+	StateReason    string
 	MaxMemoryBytes uint64
 	MemoryBytes    uint64
 	CpuTime        uint64
 	CpuCount       uint
+	// UptimeSeconds is 0 when VM is not running. UptimeSource tells where it comes from, see GetVirtualMachineUptime.
+	UptimeSeconds int64
+	UptimeSource  string
 }
 
-// Versions - originally created for testing purposes, not actually something we would need.
-// var libvirtVersion = *pflag.Bool("libvirt-version", false, "Returns result with version of libvirt populated")
-// var virshVersion = *pflag.Bool("virsh-version", false, "Returns result with version of virsh populated")
-// var tarsvirtVersion = *pflag.Bool("tarsvirt-version", false, "Returns result with version of tarsvirt populated")
+type VirtualMachineInterface struct {
+	Name string
+	Mac  string
+	Ipv4 []VirtualMachineAddress
+	Ipv6 []VirtualMachineAddress
+}
+
+type VirtualMachineAddress struct {
+	Addr   string
+	Prefix uint
+	Type   string // ipv4 or ipv6
+}
 
-var vm = pflag.String("vm", "", "vm of the machine to work with")
-var xmlTemplate = pflag.String("xml-template", "", "path to an xml template file that describes a machine. See qemu docs on xml templates.")
+var version = pflag.Bool("version", false, "Returns result with versions of the helper, libvirt and the hypervisor")
+
+var configPath = pflag.String("config", "", "path to a config file with flag defaults, ~/.config/libvirt-helper.yaml when omitted")
+var logLevelName = pflag.String("log-level", "warn", "least important diagnostics logged to stderr: debug, info, warn or error. info logs every command with its duration")
+var timing = pflag.Bool("timing", false, "adds DurationMs, how long the command took after connecting, to json object results")
+var connectUri = pflag.String("connect", "qemu:///system", "libvirt connection uri, e.g. qemu+ssh://root@host/system")
+var readonly = pflag.Bool("readonly", false, "opens a read only connection, for monitoring. Commands that change anything refuse to run")
+var format = pflag.String("format", "json", "output format of listing commands: json or table. --show-all prints its text listing without it. --capabilities and --domain-capabilities also take xml")
+var vm = pflag.String("vm", "", "vm of the machine to work with. --state, --start, --shutdown, --shutoff, --soft-reboot and --hard-reboot also take a comma separated list and return a result per vm")
+var vmPattern = pflag.String("vm-pattern", "", "works with all vms matching a glob, e.g. 'test-*', or a regular expression between slashes, e.g. '/^test-[0-9]+$/'. Takes the same commands as a list of vms in --vm")
+var filterState = pflag.StringSlice("filter-state", nil, "only lists vms in these states with --show-all: running, paused, shutoff or other. Comma separated or repeated")
+var withMemory = pflag.Bool("with-memory", false, "adds memory columns to the --show-all text listing and table")
+var withCpu = pflag.Bool("with-cpu", false, "adds a cpu time column to the --show-all text listing and table")
+var withVcpus = pflag.Bool("with-vcpus", false, "adds a vcpu count column to the --show-all text listing and table")
+var concurrency = pflag.Int("concurrency", 8, "how many vms --show-all and --ips query at once")
+var dryRun = pflag.Bool("dry-run", false, "returns a plan of what --delete, --shutoff, --create, --volume-delete or a command over several vms would do, without changing anything")
+var vmUuid = pflag.String("uuid", "", "uuid of the machine to work with, can be used instead of --vm")
+var vmId = pflag.Int("id", -1, "numeric id of a running machine to work with, can be used instead of --vm")
+var xmlTemplate = pflag.String("xml-template", "", "path or http(s) url of an xml template that describes a machine. See qemu docs on xml templates. Domain templates are rendered as go text/template, see --set")
+var deviceXml = pflag.String("device-xml", "", "path to an xml file with a single device definition, e.g. <hostdev>, <tpm> or <watchdog>")
+var targetDev = pflag.String("target-dev", "", "target device name of a vm disk, as seen in the domain xml. E.g. vda")
+var source = pflag.String("source", "", "path to a source file, e.g. an iso image for --change-media")
+var output = pflag.String("output", "", "path to an output file, e.g. for --screenshot")
+var screen = pflag.Uint("screen", 0, "index of a display head for --screenshot, when vm has several of them")
+var holdTime = pflag.Uint("hold-time", 0, "how long to hold the keys of --send-key, in milliseconds. 0 lets hypervisor decide")
+var password = pflag.String("password", "", "password for --set-graphics-password, empty clears it")
+var validity = pflag.Duration("validity", 0, "how long the password of --set-graphics-password is valid, e.g. 30m. 0 means forever")
+var live = pflag.Bool("live", false, "apply the change to a running vm. Can be combined with --persistent. Without both hypervisor picks the current state")
+var persistent = pflag.Bool("persistent", false, "apply the change to the vm configuration, so it survives a restart. Can be combined with --live")
+var size = pflag.String("size", "", "size with an optional suffix: K, M, G, T (binary) or KB, MB, GB, TB (decimal). Bare number is bytes")
+var storagePool = pflag.String("pool", "", "name of a storage pool to work with")
+var volumeName = pflag.String("volume-name", "", "name of a storage volume to work with")
+var volumeFormat = pflag.String("volume-format", "qcow2", "format of a new storage volume: qcow2 or raw")
+var backingVol = pflag.String("backing-vol", "", "backing volume of a new qcow2 volume, either a volume name in the same pool or a path")
+var wipe = pflag.Bool("wipe", false, "overwrite volume data before --volume-delete")
+var force = pflag.Bool("force", false, "proceed even when the operation looks unsafe, e.g. deleting a volume that is still used by a vm")
+var delta = pflag.Bool("delta", false, "treat --size of --volume-resize as an amount to add rather than a new capacity")
+var shrink = pflag.Bool("shrink", false, "allow --volume-resize to make a volume smaller. Data past the new capacity is lost")
+var virtualNetwork = pflag.String("network", "", "name of a virtual network to work with")
+var startNetwork = pflag.Bool("start-network", false, "start a network right after --network-create")
+var autostart = pflag.Bool("autostart", false, "mark the created object to be started with the host")
+var ipSource = pflag.String("ip-source", "", "where --ips gets addresses from: agent, lease or arp. By default the guest agent is asked, falling back to dhcp leases")
+var includeLoopback = pflag.Bool("include-loopback", false, "show loopback addresses (127.0.0.1, ::1) in --ips output")
+var timeout = pflag.Duration("timeout", 0, "how long a command may take, e.g. 90s or 5m. Commands that wait, e.g. --wait-for, stop waiting then and report where they are, the others fail with exit code 124. 0 is no limit")
+var shutdownTimeout = pflag.Duration("shutdown-timeout", 0, "how long --shutdown waits for the guest before killing the vm, e.g. 2m. 0 does not wait")
+var shutdownMode = pflag.String("shutdown-mode", "", "how --shutdown asks the guest to go down: acpi, agent, initctl, signal or paravirt. Several modes can be comma separated. Hypervisor default when omitted")
+var rebootMode = pflag.String("reboot-mode", "", "how --soft-reboot asks the guest to reboot: acpi, agent, initctl, signal or paravirt. Several modes can be comma separated. Hypervisor default when omitted")
+var cloudInitUserData = pflag.String("cloud-init-user-data", "", "cloud-init user-data file to seed a vm with on --create. It is put on a NoCloud iso in --pool, default when omitted, and attached as a cdrom")
+var cloudInitMetaData = pflag.String("cloud-init-meta-data", "", "cloud-init meta-data file for --cloud-init-user-data. Defaults to the vm name as instance id and hostname")
+var transient = pflag.Bool("transient", false, "make --create start a transient vm, that is gone once it is stopped, instead of defining a persistent one")
+var startAfterCreate = pflag.Bool("start-after-create", false, "start a vm right after --create. The vm is undefined again if it fails to start")
+var vmName = pflag.String("name", "", "name of the vm --generate-template describes")
+var memory = pflag.String("memory", "1G", "memory of the vm --generate-template describes, with an optional size suffix")
+var vcpus = pflag.Uint("vcpus", 1, "vcpu count of the vm --generate-template describes")
+var diskPath = pflag.String("disk-path", "", "disk image or host block device of the vm --generate-template describes. No disk when omitted")
+var osVariant = pflag.String("os-variant", "", "guest os of the vm --generate-template describes, e.g. ubuntu24.04 or win11. Windows gets sata and e1000e instead of virtio")
+var templateValues = pflag.StringArray("set", nil, "key=value to substitute into --xml-template as {{.key}}. The value is xml escaped. Can be repeated")
+var templateFileValues = pflag.StringArray("set-file", nil, "key=path, same as --set but the value is read from a file. Can be repeated")
+var noValidate = pflag.Bool("no-validate", false, "skip checking the --create xml against the libvirt domain schema")
+var removeStorage = pflag.Bool("remove-storage", false, "make --delete also delete disk images of the vm. Removable media, read-only disks and images other vms or overlay images still use are kept")
+var removeNvram = pflag.Bool("remove-nvram", false, "make --delete also delete the uefi nvram file of the vm, instead of keeping it")
+var mountPoints = pflag.StringArray("mountpoint", nil, "guest mountpoint for --fs-freeze, --fs-thaw and --fs-trim, e.g. /var/lib/mysql. Can be repeated. All filesystems when omitted")
+var minimum = pflag.String("minimum", "", "smallest free extent --fs-trim discards, with an optional size suffix. Guest default when omitted")
+var syncClock = pflag.Bool("sync", false, "make --set-time sync the guest clock with the host")
+var epoch = pflag.Int64("epoch", 0, "unix time in seconds --set-time sets the guest clock to")
+var suspendTarget = pflag.String("target", "mem", "where --pm-suspend suspends the guest to: mem, disk or hybrid")
+var wakeupAfter = pflag.Duration("wakeup-after", 0, "make --pm-suspend wake the guest up by itself after a duration, e.g. 10m. Not every hypervisor supports it. 0 sleeps until --pm-wakeup")
+var guestUser = pflag.String("guest-user", "", "guest user account --set-user-ssh-keys works on, e.g. root")
+var sshKeyFiles = pflag.StringArray("ssh-key-file", nil, "public key file for --set-user-ssh-keys, e.g. ~/.ssh/id_ed25519.pub. Can be repeated, a file may hold several keys")
+var appendKeys = pflag.Bool("append", false, "make --set-user-ssh-keys add to the authorized keys of the user instead of replacing them")
+var guestCmd = pflag.String("cmd", "", "path of the program --guest-exec runs in the guest, e.g. /usr/bin/systemctl")
+var guestArgs = pflag.StringArray("arg", nil, "argument of the program --guest-exec runs. Can be repeated")
+var bootDevs = pflag.StringArray("boot-dev", nil, "target=order boot order of a single disk or interface, e.g. vda=1 or 52:54:00:12:34:56=2. Can be repeated, devices not given do not boot")
+var secureBoot = pflag.Bool("secure-boot", false, "turns uefi secure boot of a vm on, or off with --secure-boot=false. The vm has to be shut off, its nvram vars are created anew")
+var bootMenu = pflag.Bool("boot-menu", false, "turns the firmware boot menu of a vm on, or off with --boot-menu=false. Takes effect on the next start")
+var tpmVersion = pflag.String("tpm-version", "2.0", "version of the tpm --add-tpm emulates: 1.2 or 2.0")
+var tpmModel = pflag.String("tpm-model", "", "model of the tpm --add-tpm adds: tpm-tis or tpm-crb. Picked from what the host supports when omitted")
+var watchdogModel = pflag.String("watchdog-model", "", "model of the watchdog --set-watchdog adds: i6300esb or itco. Defaults to the model the vm has, or i6300esb")
+var watchdogAction = pflag.String("watchdog-action", "reset", "what the hypervisor does when the --set-watchdog watchdog fires: reset, poweroff, shutdown, pause, dump, inject-nmi or none")
+var rngBackend = pflag.String("rng-backend", "/dev/urandom", "host entropy source --add-rng feeds the guest from")
+var rngRateBytes = pflag.Uint64("rng-rate-bytes", 0, "bytes of entropy the --add-rng device hands out per --rng-rate-period. 0 is unlimited")
+var rngRatePeriod = pflag.Duration("rng-rate-period", time.Second, "period of --rng-rate-bytes, e.g. 500ms")
+var videoModel = pflag.String("video-model", "", "video model --set-video gives a vm: virtio, qxl, vga, bochs, ramfb or none for a headless vm")
+var vram = pflag.String("vram", "", "video memory --set-video gives a vm, with an optional size suffix, e.g. 64M. Hypervisor default when omitted")
+var assignIOThreads = pflag.StringArray("assign-iothread", nil, "target=iothread that serves a virtio or virtio-scsi disk, e.g. vda=1. 0 goes back to the main thread. Can be repeated")
+var numaMode = pflag.String("numa-mode", "", "how --set-numatune binds vm memory to --nodeset: strict, preferred, interleave or restrictive")
+var nodeset = pflag.String("nodeset", "", "host numa nodes --set-numatune places vm memory on, e.g. 0-1 or 0,2")
+var cpuFeatures = pflag.StringArray("cpu-feature", nil, "cpu feature --set-cpu-model requires, e.g. +aes, or disables, e.g. -svm. Can be repeated")
+var cpuSet = pflag.String("cpu-set", "", "host cpus --pin-emulator confines the emulator threads to, e.g. 0-1 or 0-7,^4")
+var dest = pflag.String("dest", "", "destination of --block-copy: a file path, or a volume name when --pool is given")
+var active = pflag.Bool("active", false, "make --block-commit merge the active image too. The disk has to be switched to the base with --block-job-pivot afterwards")
+var bandwidth = pflag.Uint64("bandwidth", 0, "bandwidth limit of a block job in MiB/s. 0 is unlimited")
+var checkpointName = pflag.String("checkpoint-name", "", "name of a vm checkpoint. libvirt names new checkpoints by their creation time when omitted")
+var backupXml = pflag.String("backup-xml", "", "path to a domainbackup xml file that describes disks to back up and their targets")
+var incremental = pflag.String("incremental", "", "name of a checkpoint --backup-begin copies changes since. Full backup when omitted")
+var readIops = pflag.Uint64("read-iops", 0, "read operations per second --set-device-iops and --set-disk-iotune allow, 0 lifts the limit")
+var writeIops = pflag.Uint64("write-iops", 0, "write operations per second --set-device-iops and --set-disk-iotune allow, 0 lifts the limit")
+var totalIops = pflag.Uint64("total-iops", 0, "read and write operations per second --set-disk-iotune allows together, 0 lifts the limit")
+var totalBytesSec = pflag.String("total-bytes-sec", "", "bytes per second --set-disk-iotune allows to read and write together, with an optional size suffix. 0 lifts the limit")
+var readBytesSec = pflag.String("read-bytes-sec", "", "bytes per second --set-disk-iotune allows to read, with an optional size suffix. 0 lifts the limit")
+var writeBytesSec = pflag.String("write-bytes-sec", "", "bytes per second --set-disk-iotune allows to write, with an optional size suffix. 0 lifts the limit")
+var perCpu = pflag.Bool("per-cpu", false, "make --host-cpu-stats show every host cpu, not just the total")
+var capability = pflag.String("cap", "", "show only host devices with a capability in --node-device-list, e.g. pci, usb_device, net or scsi_host")
+var usbVendor = pflag.String("vendor", "", "hex vendor id of a host usb device for --attach-usb and --detach-usb, e.g. 046d as shown by lsusb")
+var usbProduct = pflag.String("product", "", "hex product id of a host usb device for --attach-usb and --detach-usb, e.g. c52b")
+var usbAddress = pflag.String("usb-address", "", "address of a host usb device as bus:device, e.g. 001:004 as shown by lsusb. Tells apart several devices of the same vendor and product")
+var pciAddress = pflag.String("pci-address", "", "address of a host pci device as domain:bus:slot.function, e.g. 0000:01:00.0, as shown by lspci -D")
+var pf = pflag.String("pf", "", "pci address of an sr-iov capable host nic, the physical function, e.g. 0000:03:00.0")
+var vfMode = pflag.String("vf-mode", "interface", "how --attach-vf passes a virtual function through: interface or hostdev")
+var arch = pflag.String("arch", "", "guest architecture for --domain-capabilities, e.g. x86_64. Host architecture when omitted")
+var machine = pflag.String("machine", "", "machine type for --domain-capabilities, e.g. q35. Emulator default when omitted")
+var emulator = pflag.String("emulator", "", "path to an emulator binary for --domain-capabilities, e.g. /usr/bin/qemu-system-x86_64")
+var virtType = pflag.String("virt-type", "", "virtualization type for --domain-capabilities: kvm or qemu. kvm when available")
 
 // VirtualMachine commands
 var virtualMachineState = pflag.Bool("state", false, "Returns result with a current machine state")
@@ -50,34 +180,269 @@ var virtualMachineStart = pflag.Bool("start", false, "starts up a VM. Returns re
 var virtualMachinePause = pflag.Bool("pause", false, "stops the execution of the VM. CPU is not used, but memory is still occupied. Returns result with a current machine state")
 var virtualMachineResume = pflag.Bool("resume", false, "called after Pause, to resume the invocation of the VM. Returns result with a current machine state")
 var virtualMachineCreate = pflag.Bool("create", false, "creates a new machine. Requires --xml-template parameter. Returns result with a current machine state")
+var generateTemplate = pflag.Bool("generate-template", false, "prints a minimal domain xml with defaults of the host for --create. Takes --name, --memory, --vcpus, --disk-path, --network and --os-variant parameters")
 var virtualMachineDelete = pflag.Bool("delete", false, "deletes an existing machine.")
-var virtualMachinesIps = pflag.Bool("ips", false, "show ip addresses vm on host.")
-var virtualMachinesStateAll = pflag.Bool("show-all", false, "show status all vms on host.")
+var virtualMachinesIps = pflag.Bool("ips", false, "show ip addresses of a vm, or of all running vms on host when --vm is omitted.")
+var virtualMachinesStateAll = pflag.Bool("show-all", false, "show status all vms on host. Prints a text listing unless --format is given")
+var virtualMachineNetStats = pflag.Bool("net-stats", false, "show traffic counters for every interface of a vm.")
+var virtualMachinesMetrics = pflag.Bool("metrics", false, "show metrics of all vms on host in prometheus text format.")
+var virtualMachineBlockResize = pflag.Bool("block-resize", false, "resizes a vm disk. Requires --target-dev and --size parameters. Returns result with a new disk capacity")
+var virtualMachineChangeMedia = pflag.Bool("change-media", false, "changes media in a vm cdrom. Requires --target-dev, empty --source ejects the media. Returns result with a current media source")
+var virtualMachineEject = pflag.Bool("eject", false, "ejects media from a vm cdrom. Requires --target-dev. Same as --change-media without --source")
+var virtualMachineScreenshot = pflag.Bool("screenshot", false, "takes a screenshot of a vm display. Requires --output parameter. Returns result with a mime type of the image")
+var virtualMachineSendKey = pflag.String("send-key", "", "presses a key combination in a vm. Takes a comma separated list of key names or raw linux keycodes, e.g. ctrl,alt,delete")
+var virtualMachineGraphicsInfo = pflag.Bool("graphics-info", false, "shows type, listen address and ports of vm vnc/spice displays.")
+var virtualMachineSetGraphicsPassword = pflag.Bool("set-graphics-password", false, "sets a password of vm vnc/spice displays. Takes --password and --validity parameters. Returns result with a password expiration time")
+var virtualMachineSetTitle = pflag.String("set-title", "", "sets a short title of a vm, empty clears it. Can be combined with --set-description. Returns result with a current metadata")
+var virtualMachineSetDescription = pflag.String("set-description", "", "sets a description of a vm, empty clears it. Returns result with a current metadata")
+var virtualMachineGetMetadata = pflag.Bool("get-metadata", false, "shows a title and a description of a vm.")
+var virtualMachineAttachDevice = pflag.Bool("attach-device", false, "attaches a device to a vm. Requires --device-xml parameter")
+var virtualMachineDetachDevice = pflag.Bool("detach-device", false, "detaches a device from a vm. Requires --device-xml parameter with the same device that was attached")
+var virtualMachineSetBootOrder = pflag.String("set-boot-order", "", "sets device types a vm boots from in order, e.g. cdrom,hd,network. Takes effect on the next start. Returns result with the boot order")
+var virtualMachineSetFirmware = pflag.String("set-firmware", "", "switches a vm between efi and bios firmware. The vm has to be shut off, its nvram vars are created anew. Returns result with the firmware config")
+var virtualMachineAddTpm = pflag.Bool("add-tpm", false, "adds an emulated tpm to a vm, replacing the one it has. Takes --tpm-version and --tpm-model parameters. Requires swtpm on the host. Returns result with the tpm config")
+var virtualMachineRemoveTpm = pflag.Bool("remove-tpm", false, "removes the tpm of a vm")
+var virtualMachineSetWatchdog = pflag.Bool("set-watchdog", false, "adds a watchdog to a vm, or changes the action of the one it has. Takes --watchdog-model, --watchdog-action, --live and --persistent parameters. Returns result with the watchdog config")
+var virtualMachineAddRng = pflag.Bool("add-rng", false, "adds a virtio entropy source to a vm. Takes --rng-backend, --rng-rate-bytes, --rng-rate-period, --live and --persistent parameters. Returns result with the entropy sources of the vm")
+var virtualMachineSetVideo = pflag.Bool("set-video", false, "changes the video model and memory of a vm. Requires --video-model parameter, takes --vram. Takes effect on the next start. Returns result with the video config")
+
+// Storage commands
+var storagePoolList = pflag.Bool("pool-list", false, "show all storage pools on host with their capacity.")
+var storageVolumeList = pflag.Bool("volume-list", false, "show all volumes of a storage pool. Requires --pool parameter.")
+var storageVolumeCreate = pflag.Bool("volume-create", false, "creates a new storage volume. Requires --pool, --volume-name and --size parameters. Returns result with a new volume path")
+var storageVolumeDelete = pflag.Bool("volume-delete", false, "deletes a storage volume. Requires --pool and --volume-name parameters. Returns result with freed bytes")
+var storageVolumeResize = pflag.Bool("volume-resize", false, "resizes a storage volume. Requires --pool, --volume-name and --size parameters. Returns result with a new volume capacity")
+var storagePoolRefresh = pflag.Bool("pool-refresh", false, "rescans a storage pool for new volumes. Refreshes all active pools when --pool is omitted. Returns result with refresh durations")
+
+// Network commands
+var networkList = pflag.Bool("network-list", false, "show all virtual networks on host.")
+var networkLeases = pflag.Bool("network-leases", false, "show dhcp leases of a virtual network. Requires --network parameter.")
+var networkCreate = pflag.Bool("network-create", false, "defines a new virtual network. Requires --xml-template parameter with a network xml. Returns result with a network name and bridge")
+var networkDestroy = pflag.Bool("network-destroy", false, "stops a virtual network. Requires --network parameter. Refuses to stop a network used by running vms without --force")
+var networkUndefine = pflag.Bool("network-undefine", false, "removes a virtual network definition. Requires --network parameter.")
+
+// Lifecycle commands
+var virtualMachineWaitFor = pflag.String("wait-for", "", "waits until a vm reaches a state: running, shutoff, paused etc. Takes --timeout parameter. Returns result with a current machine state")
+var virtualMachineWatchEvents = pflag.Bool("watch-events", false, "prints lifecycle events of a vm, or of all vms when --vm is omitted, as json lines until interrupted")
+
+// Guest agent commands
+var virtualMachineGuestInfo = pflag.Bool("guest-info", false, "shows os, hostname, timezone, users and filesystems of a guest. Requires the guest agent")
+var virtualMachineAgentPing = pflag.Bool("agent-ping", false, "checks whether the guest agent responds. Takes --timeout parameter. Returns result with a round-trip time")
+var virtualMachineFSFreeze = pflag.Bool("fs-freeze", false, "freezes guest filesystems before a snapshot. Takes --mountpoint parameter. Requires the guest agent. Returns result with frozen filesystems")
+var virtualMachineFSThaw = pflag.Bool("fs-thaw", false, "thaws guest filesystems frozen by --fs-freeze. Takes --mountpoint parameter. Returns result with thawed filesystems")
+var virtualMachineFSTrim = pflag.Bool("fs-trim", false, "discards unused blocks of guest filesystems to shrink thin provisioned disks. Takes --mountpoint and --minimum parameters. Requires the guest agent")
+var virtualMachineFSInfo = pflag.Bool("fs-info", false, "shows mounted guest filesystems and vm disks they live on. Requires the guest agent")
+var virtualMachineSetTime = pflag.Bool("set-time", false, "sets the guest clock. Requires either --sync or --epoch parameter and the guest agent. Returns result with the guest time")
+var virtualMachinePMSuspend = pflag.Bool("pm-suspend", false, "suspends a guest by its own power management. Takes --target, --wakeup-after and --timeout parameters. Requires the guest agent. Returns result with a current machine state")
+var virtualMachineSetUserSSHKeys = pflag.Bool("set-user-ssh-keys", false, "sets authorized ssh keys of a guest user. Requires --guest-user and --ssh-key-file parameters, takes --append. Requires the guest agent. Returns result with a number of keys set")
+var virtualMachineGuestExec = pflag.Bool("guest-exec", false, "runs a program in the guest and waits for it to exit. Requires --cmd parameter, takes --arg and --timeout. Requires the guest agent. Returns result with an exit code and output")
+var virtualMachineGuestHostname = pflag.Bool("guest-hostname", false, "shows the hostname the guest reports. Requires the guest agent")
+var virtualMachinePMWakeup = pflag.Bool("pm-wakeup", false, "wakes up a guest suspended to mem by --pm-suspend. Takes --timeout parameter. Returns result with a current machine state")
+
+// Job commands
+var virtualMachineJobInfo = pflag.Bool("job-info", false, "shows progress of a running migration, save, dump or backup job of a vm, and of block jobs on its disks.")
+var virtualMachineJobAbort = pflag.Bool("job-abort", false, "cancels a running migration, save, dump or backup job of a vm.")
+var virtualMachineBlockCopy = pflag.Bool("block-copy", false, "starts mirroring a running vm disk to new storage. Requires --target-dev and --dest parameters, takes --pool and --volume-format. Watch it with --job-info")
+var virtualMachineBlockJobPivot = pflag.Bool("block-job-pivot", false, "switches a vm disk over to its --block-copy or --active --block-commit once in sync. Requires --target-dev parameter. Returns result with the new disk source")
+var virtualMachineBlockCommit = pflag.Bool("block-commit", false, "merges snapshot overlays of a vm disk into its base image. Requires --target-dev parameter, takes --active and --timeout. Returns result with the backing chain")
+var virtualMachineBlockPull = pflag.Bool("block-pull", false, "copies backing images of a vm disk into its active image, so it stands alone. Requires --target-dev parameter, takes --bandwidth and --timeout. Returns result with the backing chain")
+var virtualMachineBlockJobSpeed = pflag.Bool("block-job-speed", false, "limits bandwidth of a block job running on a vm disk. Requires --target-dev and --bandwidth parameters. Returns result with the block job")
+
+// Backup commands
+var virtualMachineCheckpointCreate = pflag.Bool("checkpoint-create", false, "starts tracking changed blocks of vm disks for incremental backups. Takes --checkpoint-name parameter. Returns result with the checkpoint name and creation time")
+var virtualMachineCheckpointList = pflag.Bool("checkpoint-list", false, "show checkpoints of a vm, oldest first.")
+var virtualMachineBackupBegin = pflag.Bool("backup-begin", false, "starts a backup of a running vm. Requires --backup-xml parameter, takes --incremental and --checkpoint-name to create a checkpoint for the next one. Watch it with --job-info")
+var virtualMachineBackupEnd = pflag.Bool("backup-end", false, "ends a running backup of a vm, cancelling it when not finished yet.")
+
+// Tuning commands
+var virtualMachineSetCpuShares = pflag.Uint64("set-cpu-shares", 0, "sets the relative cpu weight of a vm against other vms. Can be combined with --set-cpu-quota and --set-cpu-period. Returns result with current scheduler values")
+var virtualMachineSetCpuQuota = pflag.Int64("set-cpu-quota", 0, "sets how many microseconds each vcpu of a vm may run within a period, -1 is unlimited. Returns result with current scheduler values")
+var virtualMachineSetCpuPeriod = pflag.Uint64("set-cpu-period", 0, "sets the period of --set-cpu-quota in microseconds. Returns result with current scheduler values")
+var virtualMachineGetScheduler = pflag.Bool("get-scheduler", false, "shows cpu shares, quota and period of a vm.")
+var virtualMachineSetBlkioWeight = pflag.Uint64("set-blkio-weight", 0, "sets the relative block io weight of a vm against other vms, 100 to 1000. Returns result with current blkio values")
+var virtualMachineSetDeviceIops = pflag.String("set-device-iops", "", "limits iops of a vm on a host block device backing one of its disks, e.g. /dev/sdb. Takes --read-iops and --write-iops parameters. Returns result with current blkio values")
+var virtualMachineGetBlkio = pflag.Bool("get-blkio", false, "shows block io weight and per device limits of a vm.")
+var virtualMachineSetMemoryHardLimit = pflag.String("set-memory-hard-limit", "", "sets the most host memory a vm may use, with an optional size suffix, or unlimited. Can be combined with --set-memory-soft-limit and --set-swap-hard-limit. Returns result with current limits")
+var virtualMachineSetMemorySoftLimit = pflag.String("set-memory-soft-limit", "", "sets host memory a vm is pushed back to under memory pressure, with an optional size suffix, or unlimited. Returns result with current limits")
+var virtualMachineSetSwapHardLimit = pflag.String("set-swap-hard-limit", "", "sets the most host memory plus swap a vm may use, with an optional size suffix, or unlimited. Returns result with current limits")
+var virtualMachineGetMemtune = pflag.Bool("get-memtune", false, "shows host memory limits of a vm in bytes.")
+var virtualMachineSetDiskIotune = pflag.Bool("set-disk-iotune", false, "throttles a vm disk. Requires --target-dev parameter, takes --total-bytes-sec, --read-bytes-sec, --write-bytes-sec, --total-iops, --read-iops and --write-iops. Returns result with current limits")
+var virtualMachineGetDiskIotune = pflag.Bool("get-disk-iotune", false, "shows throttling limits of a vm disk. Requires --target-dev parameter.")
+var virtualMachineSetIOThreads = pflag.Uint("set-iothreads", 0, "sets how many iothreads a vm has for its disks. Takes --assign-iothread parameter. Takes effect on the next start. Returns result with disks and their iothreads")
+var virtualMachineSetNumatune = pflag.Bool("set-numatune", false, "places the memory of a vm on host numa nodes. Takes --numa-mode, --nodeset, --live and --persistent parameters. Returns result with current numa placement")
+var virtualMachineSetCpuModel = pflag.String("set-cpu-model", "", "sets the cpu a vm sees: host-passthrough, host-model or a named model, e.g. Skylake-Server. Takes --cpu-feature parameter. Takes effect on the next start. Returns result with the cpu definition")
+var virtualMachinePinEmulator = pflag.Bool("pin-emulator", false, "confines the emulator threads of a vm to host cpus. Requires --cpu-set parameter, takes --live and --persistent. Returns result with the emulator affinity")
+var virtualMachineGetEmulatorPin = pflag.Bool("get-emulator-pin", false, "shows host cpus the emulator threads of a vm may run on. Takes --live and --persistent parameters")
+var virtualMachineGetNumatune = pflag.Bool("get-numatune", false, "shows how the memory of a vm is placed on host numa nodes. Takes --live and --persistent parameters")
+
+// Host commands
+var keepaliveInterval = pflag.Int("keepalive-interval", 5, "seconds between keepalive messages of --serve and --watch-events connections, 0 turns keepalive off")
+var keepaliveCount = pflag.Uint("keepalive-count", 3, "how many keepalive messages may go unanswered before the connection counts as dead")
+var serve = pflag.String("serve", "", "runs an http api on an address, e.g. 127.0.0.1:8080, until stopped. An address without host, e.g. :8080, also listens on 127.0.0.1 only. GET /health, /vms, /vm/{name}/state and /vm/{name}/ips, POST /vm/{name}/start, shutdown, shutoff, soft-reboot, hard-reboot, pause and resume. All but /health want the bearer token of --serve-token-file or LIBVIRT_HELPER_TOKEN")
+var serveTokenFile = pflag.String("serve-token-file", "", "file holding the bearer token --serve clients have to send. Without it the token is taken from LIBVIRT_HELPER_TOKEN")
+var hostInfo = pflag.Bool("host-info", false, "shows hostname, hypervisor, cpus and memory of the host.")
+var hostMemory = pflag.Bool("host-memory", false, "shows total and free memory of the host and of each of its numa cells.")
+var hostCpuStats = pflag.Bool("host-cpu-stats", false, "shows cumulative user, kernel, idle and iowait times of host cpus in nanoseconds. Takes --per-cpu parameter. Diff two samples to get utilization")
+var nodeDeviceList = pflag.Bool("node-device-list", false, "show host devices with their parents and capabilities. Takes --cap parameter.")
+var nodeDeviceXml = pflag.String("node-device-xml", "", "prints the xml of a host device by its name, e.g. pci_0000_01_00_0")
+var virtualMachineAttachHostdev = pflag.Bool("attach-hostdev", false, "passes a host pci device through to a vm, taking it from its host driver. Requires --pci-address parameter")
+var virtualMachineDetachHostdev = pflag.Bool("detach-hostdev", false, "takes a host pci device away from a vm and gives it back to its host driver. Requires --pci-address parameter")
+var virtualMachineAttachUsb = pflag.Bool("attach-usb", false, "passes a host usb device through to a vm. Requires either --vendor and --product or --usb-address parameter. Returns result with the attached device")
+var virtualMachineDetachUsb = pflag.Bool("detach-usb", false, "takes a host usb device away from a vm. Requires either --vendor and --product or --usb-address parameter, as given on --attach-usb")
+var virtualMachineSetUsbRedir = pflag.Uint("set-usb-redir", 0, "sets how many usb devices a spice client may redirect into a vm, 0 removes redirection. Takes effect on the next start. Returns result with the redirection channels")
+var virtualFunctionList = pflag.Bool("list-vfs", false, "show sr-iov virtual functions of a host nic and running vms using them. Requires --pf parameter.")
+var virtualMachineAttachVf = pflag.Bool("attach-vf", false, "passes a free sr-iov virtual function of a host nic through to a vm. Requires --pf parameter, takes --vf-mode")
+var hostCapabilities = pflag.Bool("capabilities", false, "shows the host cpu, security models and guest architectures the hypervisor runs.")
+var domainCapabilities = pflag.Bool("domain-capabilities", false, "shows disk buses, video models, max vcpus etc. a vm may use. Takes --arch, --machine, --emulator and --virt-type parameters")
 
 var libvirtInstance *libvirt.Connect
 
 // TODO: cool things you can do with Domain, but do not know how to:
 // virDomainInterfaceAddresses - gets data about an IP addresses on a current interfaces. Mega-tool.
-// virDomainGetGuestInfo - full data about a config of the guest OS
-// virDomainGetState - provides the data about an actual domain state. Why is it shutoff or hybernating. Requires copious amount of magic fuckery to find out the actual reason with multiplication and matrix transforms, but can be translated into a redable form.
 func main() {
 
+	pflag.Usage = PrintUsage
 	pflag.Parse()
 
-	LibvirtInit()
+	// defaults come in before anything is checked, so the checks see the values the command runs with.
+	ConfigPath := *configPath
+	if ConfigPath == "" {
+		ConfigPath = DefaultConfigPath()
+	}
+	if ConfigPath != "" {
+		if err := LoadConfig(ConfigPath, *configPath != ""); err != nil {
+			hfail(err)
+		}
+	}
+
+	if pflag.NArg() > 0 {
+		if err := DispatchCommand(pflag.Args()); err != nil {
+			hfail(err)
+		}
+	}
+	SelectedCmd, err := SelectedCommand()
+	if err != nil {
+		hfail(err)
+	}
+
+	if logLevel, err = ParseLogLevel(*logLevelName); err != nil {
+		hfail(err)
+	}
+
+	if SelectedCmd != nil {
+		if err := SelectedCmd.CheckRequired(); err != nil {
+			hfail(err)
+		}
+		if *readonly && !SelectedCmd.ReadOnly {
+			hfail(fmt.Errorf("%v changes the host or a vm, it does not run with --readonly", SelectedCmd.Name))
+		}
+	}
+
+	if *vmPattern != "" && (*vm != "" || *vmUuid != "" || *vmId >= 0) {
+		hfail(errors.New("--vm-pattern picks the vms itself, it can not be combined with --vm, --uuid or --id"))
+	}
+
+	// event callbacks and keepalive only work when an event loop was registered before the connection is opened.
+	if *virtualMachineWatchEvents || *serve != "" {
+		RunEventLoop()
+	}
+
+	// libvirt calls block without a way to cancel them, so the context bounds our own waiting and ExitAfterDeadline the rest.
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		// the server runs until stopped, --timeout only bounds connecting for it.
+		if *serve == "" {
+			go ExitAfterDeadline(ctx)
+		}
+	}
+
+	LibvirtInit(ctx)
 	defer libvirtInstance.Close()
 
+	// connecting is logged by itself, so the duration is that of the command.
+	if SelectedCmd != nil {
+		operation.Name, operation.Vm, operation.Start = SelectedCmd.Name, *vm, time.Now()
+	}
+	defer LogOperation(0)
+
+	if *vmUuid != "" || *vmId >= 0 {
+		*vm = ResolveVirtualMachineName(*vm, *vmUuid, *vmId)
+	}
+
+	vms := VirtualMachineNames(*vm)
+	if *vmPattern != "" {
+		var err error
+		if vms, err = MatchVirtualMachines(*vmPattern); err != nil {
+			hfail(err)
+		}
+	}
+	if len(vms) > 1 || *vmPattern != "" {
+		// refused commands get no plan either.
+		if !(*virtualMachineState || *virtualMachineStart || *virtualMachineShutdown || *virtualMachineShutoff ||
+			*virtualMachineSoftReboot || *virtualMachineHardReboot) {
+			hfail(errors.New("only --state, --start, --shutdown, --shutoff, --soft-reboot and --hard-reboot take several vms or --vm-pattern"))
+		}
+		if *dryRun && SelectedCmd != nil && !SelectedCmd.ReadOnly {
+			hret(VirtualMachineBatchPlan(SelectedCmd.Name, vms))
+		}
+
+		switch {
+		case *virtualMachineState:
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				return nil
+			})
+		case *virtualMachineStart:
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				return d.Create()
+			})
+		case *virtualMachineShutdown:
+			flags, err := ParseShutdownMode(*shutdownMode)
+			if err != nil {
+				hfail(err)
+			}
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				_, err := ShutdownVirtualMachine(ctx, vm, d, flags, *shutdownTimeout)
+				return err
+			})
+		case *virtualMachineShutoff:
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				return d.Destroy()
+			})
+		case *virtualMachineSoftReboot:
+			flags, err := ParseRebootMode(*rebootMode)
+			if err != nil {
+				hfail(err)
+			}
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				return d.Reboot(flags)
+			})
+		case *virtualMachineHardReboot:
+			VirtualMachinesBatch(vms, func(vm string, d *libvirt.Domain) error {
+				return d.Reset(0)
+			})
+		}
+	}
+	switch {
+	case !*dryRun, SelectedCmd == nil, SelectedCmd.ReadOnly:
+	case *virtualMachineDelete, *virtualMachineShutoff, *virtualMachineCreate, *storageVolumeDelete:
+	default:
+		hfail(fmt.Errorf("%v has no --dry-run, only vm delete, vm shutoff, vm create, volume delete and lifecycle commands over several vms do", SelectedCmd.Name))
+	}
+
 	switch {
 	case *virtualMachineState:
 		VirtualMachineState(*vm)
 	case *virtualMachineSoftReboot:
-		VirtualMachineSoftReboot(*vm)
+		VirtualMachineSoftReboot(*vm, *rebootMode)
 	case *virtualMachineHardReboot:
 		VirtualMachineHardReboot(*vm)
 	case *virtualMachineShutdown:
-		VirtualMachineShutdown(*vm)
+		VirtualMachineShutdown(ctx, *vm, *shutdownMode, *shutdownTimeout)
 	case *virtualMachineShutoff:
-		VirtualMachineShutoff(*vm)
+		VirtualMachineShutoff(*vm, *dryRun)
 	case *virtualMachineStart:
 		VirtualMachineStart(*vm)
 	case *virtualMachinePause:
@@ -85,13 +450,193 @@ func main() {
 	case *virtualMachineResume:
 		VirtualMachineResume(*vm)
 	case *virtualMachineCreate:
-		VirtualMachineCreate(*xmlTemplate)
+		VirtualMachineCreate(*xmlTemplate, *templateValues, *templateFileValues, *transient, *startAfterCreate, !*noValidate, *dryRun,
+			*cloudInitUserData, *cloudInitMetaData, *storagePool)
+	case *generateTemplate:
+		GenerateTemplate(*vmName, *memory, *vcpus, *diskPath, *virtualNetwork, *osVariant)
 	case *virtualMachineDelete:
-		VirtualMachineDelete(*vm)
+		VirtualMachineDelete(*vm, *removeStorage, *removeNvram, *force, *dryRun)
 	case *virtualMachinesIps:
-		VirtualMachinesIps()
+		VirtualMachinesIps(*vm)
 	case *virtualMachinesStateAll:
-		VirtualMachinesStateAll()
+		VirtualMachinesStateAll(*filterState, *withMemory, *withCpu, *withVcpus)
+	case *virtualMachineNetStats:
+		VirtualMachineNetStats(*vm)
+	case *virtualMachinesMetrics:
+		VirtualMachinesMetrics()
+	case *virtualMachineBlockResize:
+		VirtualMachineBlockResize(*vm, *targetDev, *size)
+	case *virtualMachineChangeMedia:
+		VirtualMachineChangeMedia(*vm, *targetDev, *source)
+	case *virtualMachineEject:
+		VirtualMachineChangeMedia(*vm, *targetDev, "")
+	case *virtualMachineScreenshot:
+		VirtualMachineScreenshot(*vm, *output, *screen)
+	case *virtualMachineSendKey != "":
+		VirtualMachineSendKey(*vm, *virtualMachineSendKey, *holdTime)
+	case *virtualMachineGraphicsInfo:
+		VirtualMachineGraphicsInfo(*vm)
+	case *virtualMachineSetGraphicsPassword:
+		VirtualMachineSetGraphicsPassword(*vm, *password, *validity)
+	case pflag.CommandLine.Changed("set-title") || pflag.CommandLine.Changed("set-description"):
+		VirtualMachineSetMetadata(*vm, ChangedString("set-title"), ChangedString("set-description"))
+	case *virtualMachineGetMetadata:
+		VirtualMachineGetMetadata(*vm)
+	case *virtualMachineAttachDevice:
+		VirtualMachineAttachDevice(*vm, *deviceXml)
+	case *virtualMachineDetachDevice:
+		VirtualMachineDetachDevice(*vm, *deviceXml)
+	case *storagePoolList:
+		StoragePoolList()
+	case *storageVolumeList:
+		StorageVolumeList(*storagePool)
+	case *storageVolumeCreate:
+		StorageVolumeCreate(*storagePool, *volumeName, *size, *volumeFormat, *backingVol)
+	case *storageVolumeDelete:
+		StorageVolumeDelete(*storagePool, *volumeName, *wipe, *force, *dryRun)
+	case *storageVolumeResize:
+		StorageVolumeResize(*storagePool, *volumeName, *size, *delta, *shrink)
+	case *storagePoolRefresh:
+		StoragePoolRefresh(*storagePool)
+	case *networkList:
+		NetworkList()
+	case *networkLeases:
+		NetworkLeases(*virtualNetwork)
+	case *networkCreate:
+		NetworkCreate(*xmlTemplate, *startNetwork, *autostart)
+	case *networkDestroy:
+		NetworkDestroy(*virtualNetwork, *force)
+	case *networkUndefine:
+		NetworkUndefine(*virtualNetwork)
+	case *virtualMachineSetBootOrder != "" || len(*bootDevs) > 0:
+		VirtualMachineSetBootOrder(*vm, *virtualMachineSetBootOrder, *bootDevs)
+	case *virtualMachineSetFirmware != "" || pflag.CommandLine.Changed("secure-boot") || pflag.CommandLine.Changed("boot-menu"):
+		VirtualMachineSetFirmware(*vm, *virtualMachineSetFirmware, ChangedBool("secure-boot"), ChangedBool("boot-menu"))
+	case *virtualMachineAddTpm:
+		VirtualMachineAddTpm(*vm, *tpmVersion, *tpmModel)
+	case *virtualMachineRemoveTpm:
+		VirtualMachineRemoveTpm(*vm)
+	case *virtualMachineSetWatchdog:
+		VirtualMachineSetWatchdog(*vm, *watchdogModel, *watchdogAction)
+	case *virtualMachineAddRng:
+		VirtualMachineAddRng(*vm, *rngBackend, *rngRateBytes, *rngRatePeriod)
+	case *virtualMachineSetVideo:
+		VirtualMachineSetVideo(*vm, *videoModel, *vram)
+	case pflag.CommandLine.Changed("set-iothreads") || len(*assignIOThreads) > 0:
+		VirtualMachineSetIOThreads(*vm, ChangedUint("set-iothreads"), *assignIOThreads)
+	case *virtualMachineSetNumatune:
+		VirtualMachineSetNumatune(*vm, *numaMode, *nodeset)
+	case *virtualMachineGetNumatune:
+		VirtualMachineGetNumatune(*vm)
+	case *virtualMachineSetCpuModel != "":
+		VirtualMachineSetCpuModel(*vm, *virtualMachineSetCpuModel, *cpuFeatures)
+	case *virtualMachinePinEmulator:
+		VirtualMachinePinEmulator(*vm, *cpuSet)
+	case *virtualMachineGetEmulatorPin:
+		VirtualMachineGetEmulatorPin(*vm)
+	case *virtualMachineWaitFor != "":
+		VirtualMachineWaitFor(ctx, *vm, *virtualMachineWaitFor)
+	case *virtualMachineWatchEvents:
+		VirtualMachineWatchEvents(*vm)
+	case *virtualMachineGuestInfo:
+		VirtualMachineGuestInfo(*vm)
+	case *virtualMachineAgentPing:
+		VirtualMachineAgentPing(*vm, *timeout)
+	case *virtualMachineFSFreeze:
+		VirtualMachineFSFreeze(*vm, *mountPoints)
+	case *virtualMachineFSThaw:
+		VirtualMachineFSThaw(*vm, *mountPoints)
+	case *virtualMachineFSTrim:
+		VirtualMachineFSTrim(*vm, *mountPoints, *minimum)
+	case *virtualMachineFSInfo:
+		VirtualMachineFSInfo(*vm)
+	case *virtualMachineSetTime:
+		VirtualMachineSetTime(*vm, *syncClock, ChangedInt64("epoch"))
+	case *virtualMachinePMSuspend:
+		VirtualMachinePMSuspend(ctx, *vm, *suspendTarget, *wakeupAfter, *timeout)
+	case *virtualMachineSetUserSSHKeys:
+		VirtualMachineSetUserSSHKeys(*vm, *guestUser, *sshKeyFiles, *appendKeys)
+	case *virtualMachineGuestExec:
+		VirtualMachineGuestExec(ctx, *vm, *guestCmd, *guestArgs)
+	case *virtualMachineGuestHostname:
+		VirtualMachineGuestHostname(*vm)
+	case *virtualMachinePMWakeup:
+		VirtualMachinePMWakeup(ctx, *vm, *timeout)
+	case *virtualMachineJobInfo:
+		VirtualMachineJobInfo(*vm)
+	case *virtualMachineJobAbort:
+		VirtualMachineJobAbort(*vm)
+	case *virtualMachineBlockCopy:
+		VirtualMachineBlockCopy(*vm, *targetDev, *dest, *storagePool, *volumeFormat)
+	case *virtualMachineBlockJobPivot:
+		VirtualMachineBlockJobPivot(*vm, *targetDev)
+	case *virtualMachineBlockCommit:
+		VirtualMachineBlockCommit(ctx, *vm, *targetDev, *active)
+	case *virtualMachineBlockPull:
+		VirtualMachineBlockPull(ctx, *vm, *targetDev, *bandwidth)
+	case *virtualMachineBlockJobSpeed:
+		VirtualMachineBlockJobSpeed(*vm, *targetDev, *bandwidth)
+	case *virtualMachineCheckpointCreate:
+		VirtualMachineCheckpointCreate(*vm, *checkpointName)
+	case *virtualMachineCheckpointList:
+		VirtualMachineCheckpointList(*vm)
+	case *virtualMachineBackupBegin:
+		VirtualMachineBackupBegin(*vm, *backupXml, *incremental, *checkpointName)
+	case *virtualMachineBackupEnd:
+		VirtualMachineBackupEnd(*vm)
+	case pflag.CommandLine.Changed("set-cpu-shares") || pflag.CommandLine.Changed("set-cpu-quota") || pflag.CommandLine.Changed("set-cpu-period"):
+		VirtualMachineSetScheduler(*vm, ChangedUint64("set-cpu-shares"), ChangedInt64("set-cpu-quota"), ChangedUint64("set-cpu-period"))
+	case *virtualMachineGetScheduler:
+		VirtualMachineGetScheduler(*vm)
+	case pflag.CommandLine.Changed("set-blkio-weight") || *virtualMachineSetDeviceIops != "":
+		VirtualMachineSetBlkio(*vm, ChangedUint64("set-blkio-weight"), *virtualMachineSetDeviceIops, ChangedUint64("read-iops"), ChangedUint64("write-iops"), *force)
+	case *virtualMachineGetBlkio:
+		VirtualMachineGetBlkio(*vm)
+	case *virtualMachineSetMemoryHardLimit != "" || *virtualMachineSetMemorySoftLimit != "" || *virtualMachineSetSwapHardLimit != "":
+		VirtualMachineSetMemtune(*vm, ChangedString("set-memory-hard-limit"), ChangedString("set-memory-soft-limit"), ChangedString("set-swap-hard-limit"))
+	case *virtualMachineGetMemtune:
+		VirtualMachineGetMemtune(*vm)
+	case *virtualMachineSetDiskIotune:
+		VirtualMachineSetDiskIotune(*vm, *targetDev, ChangedString("total-bytes-sec"), ChangedString("read-bytes-sec"), ChangedString("write-bytes-sec"),
+			ChangedUint64("total-iops"), ChangedUint64("read-iops"), ChangedUint64("write-iops"))
+	case *virtualMachineGetDiskIotune:
+		VirtualMachineGetDiskIotune(*vm, *targetDev)
+	case *serve != "":
+		Serve(*serve, *serveTokenFile)
+	case *version:
+		HelperVersions()
+	case *hostInfo:
+		HostInfo()
+	case *hostMemory:
+		HostMemoryInfo()
+	case *hostCpuStats:
+		HostCpuStatistics(*perCpu)
+	case *nodeDeviceList:
+		NodeDeviceList(*capability)
+	case *nodeDeviceXml != "":
+		NodeDeviceXML(*nodeDeviceXml)
+	case *virtualMachineAttachHostdev:
+		VirtualMachineAttachHostdev(*vm, *pciAddress)
+	case *virtualMachineDetachHostdev:
+		VirtualMachineDetachHostdev(*vm, *pciAddress)
+	case *virtualMachineAttachUsb:
+		VirtualMachineAttachUsb(*vm, *usbVendor, *usbProduct, *usbAddress)
+	case *virtualMachineDetachUsb:
+		VirtualMachineDetachUsb(*vm, *usbVendor, *usbProduct, *usbAddress)
+	case pflag.CommandLine.Changed("set-usb-redir"):
+		VirtualMachineSetUsbRedir(*vm, *virtualMachineSetUsbRedir)
+	case *virtualFunctionList:
+		VirtualFunctionList(*pf)
+	case *virtualMachineAttachVf:
+		VirtualMachineAttachVf(*vm, *pf, *vfMode)
+	case *hostCapabilities:
+		HostCapabilitiesInfo()
+	case *domainCapabilities:
+		DomainCapabilitiesInfo(*emulator, *arch, *machine, *virtType)
+	}
+
+	if errorReported {
+		hexit(1)
 	}
 }
 
@@ -101,43 +646,284 @@ func VirtualMachineState(vm string) {
 	hret(ret)
 }
 
-// VirtualMachineCreate creates a new VM from an xml template file
-func VirtualMachineCreate(xmlTemplate string) {
+// VirtualMachineCreate creates a new VM from an xml template file.
+// A transient VM is started right away and disappears once it is stopped, a persistent one is only started when asked.
+// With cloud-init user data a NoCloud seed iso is built, stored in a pool and attached as a cdrom. --delete removes it again.
+func VirtualMachineCreate(xmlTemplate string, values []string, fileValues []string, transient bool, start bool, validate bool, dryRun bool,
+	userData string, metaData string, poolName string) {
 
-	xml, err := os.ReadFile(xmlTemplate)
+	xml, err := RenderXMLTemplate(xmlTemplate, values, fileValues)
 	herr(err)
+	if err != nil {
+		return
+	}
+
+	if poolName == "" {
+		poolName = "default"
+	}
 
-	d, err := libvirtInstance.DomainDefineXML(string(xml))
+	// defining over an existing vm would silently replace it, and a failed start would then undefine it.
+	Node, err := ParseXMLNode(xml)
 	herr(err)
+	if err != nil {
+		return
+	}
+	if name := Node.Child("name"); name != nil && name.Text != "" {
+		if existing, err := libvirtInstance.LookupDomainByName(name.Text); err == nil {
+			existing.Free()
+			herr(fmt.Errorf("a vm named %v already exists, delete it first or pick another name", name.Text))
+			return
+		}
+	}
+
+	if dryRun {
+		plan, err := VirtualMachineCreatePlan(xml, transient, start)
+		herr(err)
+		if err != nil {
+			return
+		}
+		if userData != "" {
+			volume := poolName + "/" + CloudInitVolumeName(plan.Vms[0])
+			plan.Volumes = append(plan.Volumes, volume)
+			plan.Steps = append([]string{"create cloud-init iso volume " + volume}, plan.Steps...)
+		}
+		hret(plan)
+	}
+
+	var CloudInit CloudInitXML
+	if userData != "" {
+		xml, CloudInit, err = PrepareCloudInitIso(xml, poolName, userData, metaData)
+		herr(err)
+		if err != nil {
+			return
+		}
+	}
+	// the iso is of no use without its vm.
+	dropCloudInit := func() {
+		if CloudInit.Volume != "" {
+			_, err := DeleteCloudInitIso(CloudInit)
+			herr(err)
+		}
+	}
+	markCloudInit := func(d *libvirt.Domain) {
+		if CloudInit.Volume != "" {
+			herr(MarkCloudInitIso(d, CloudInit, transient))
+		}
+	}
+
+	// libvirt happily accepts parseable xml with typos in it, the schema check catches those.
+	createFlags := libvirt.DOMAIN_NONE
+	defineFlags := libvirt.DomainDefineFlags(0)
+	if validate {
+		createFlags = libvirt.DOMAIN_START_VALIDATE
+		defineFlags = libvirt.DOMAIN_DEFINE_VALIDATE
+	}
+
+	if transient {
+		d, err := libvirtInstance.DomainCreateXML(xml, createFlags)
+		herr(ValidationError(xmlTemplate, err))
+		if err != nil {
+			dropCloudInit()
+			return
+		}
+		markCloudInit(d)
+
+		name, err := d.GetName()
+		herr(err)
+
+		hret(GetVirtualMachineStateInfo(name))
+	}
+
+	d, err := libvirtInstance.DomainDefineXMLFlags(xml, defineFlags)
+	herr(ValidationError(xmlTemplate, err))
+	if err != nil {
+		dropCloudInit()
+	} else {
+		markCloudInit(d)
+	}
+
+	if start {
+		if err != nil {
+			return
+		}
+
+		name, err := d.GetName()
+		herr(err)
+
+		// do not leave a half-provisioned VM behind when it can not start.
+		if err = d.Create(); err != nil {
+			herr(err)
+			err = d.Undefine()
+			herr(err)
+			dropCloudInit()
+			return
+		}
+
+		hret(GetVirtualMachineStateInfo(name))
+	}
 
 	hret(d)
 }
 
+// ValidationError makes schema validation failures explain themselves, other errors are returned as is.
+func ValidationError(xmlTemplate string, err error) error {
+	if IsLibvirtError(err, libvirt.ERR_XML_INVALID_SCHEMA) {
+		return fmt.Errorf("%v does not match the libvirt domain schema, fix it or pass --no-validate: %v", xmlTemplate, err)
+	}
+	return err
+}
+
+type VirtualMachineDeleteInfo struct {
+	Name           string
+	RemovedVolumes []string
+	// KeptVolumes are disk images other vms or overlay images still need, left in place by --remove-storage.
+	KeptVolumes        []string
+	NvramRemoved       bool
+	ManagedSaveRemoved bool
+}
+
 // VirtualMachineDelete deletes a new VM from an xml template file
-func VirtualMachineDelete(vm string) {
+// Disk images are removed along with the VM when removeStorage is set. Removable media (e.g. an installer iso)
+// and read-only disks are left alone, as they are usually shared between VMs, and so are images another VM
+// uses or an overlay image is layered on.
+// A running VM is only deleted when forced, it is shut off first.
+func VirtualMachineDelete(vm string, removeStorage bool, removeNvram bool, force bool, dryRun bool) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	active, err := d.IsActive()
+	herr(err)
+	if active {
+		if !force {
+			herr(fmt.Errorf("%v is running, shut it down first or pass --force to shut it off", vm))
+			return
+		}
+	}
+
+	if dryRun {
+		hret(VirtualMachineDeletePlan(d, vm, active, removeStorage, removeNvram))
+	}
+
+	// the metadata is gone once the vm is undefined.
+	CloudInit, hasCloudInit := GetCloudInitIso(d)
+
+	if active {
+		err = d.Destroy()
+		herr(err)
+		if err != nil {
+			return
+		}
+	}
+
+	// users are looked up while the vm is still defined, its own disks are told apart by name.
+	var volumes, kept []string
+	if removeStorage {
+		DomXML, err := GetDomainXML(d, libvirt.DOMAIN_XML_INACTIVE)
+		herr(err)
+		if err != nil {
+			return
+		}
+		volumes, kept = VirtualMachineOwnVolumes(vm, DomXML.Devices.Disks)
+	}
+
+	flags := libvirt.DOMAIN_UNDEFINE_KEEP_NVRAM
+	if removeNvram {
+		flags = libvirt.DOMAIN_UNDEFINE_NVRAM
+	}
+
+	// otherwise the managed save image is left behind as an orphan file.
+	managedSave, err := d.HasManagedSaveImage(0)
+	herr(err)
+	if managedSave {
+		flags |= libvirt.DOMAIN_UNDEFINE_MANAGED_SAVE
+	}
 
-	err = d.UndefineFlags(libvirt.DOMAIN_UNDEFINE_KEEP_NVRAM)
+	err = d.UndefineFlags(flags)
 	herr(err)
-	hok(fmt.Sprintf("%v was deleted", vm))
+	if err != nil {
+		return
+	}
+
+	// the cloud-init iso was created by the helper for this vm alone, so it goes without --remove-storage.
+	CloudInitPath := ""
+	if hasCloudInit {
+		CloudInitPath, err = DeleteCloudInitIso(CloudInit)
+		herr(err)
+		if err != nil {
+			CloudInitPath = ""
+		}
+	}
+
+	if !removeStorage {
+		var removed []string
+		if managedSave {
+			removed = append(removed, "its managed save image")
+		}
+		if CloudInitPath != "" {
+			removed = append(removed, "its cloud-init iso")
+		}
+		if len(removed) > 0 {
+			hok(fmt.Sprintf("%v was deleted along with %v", vm, strings.Join(removed, " and ")))
+		}
+		hok(fmt.Sprintf("%v was deleted", vm))
+	}
+
+	ret := VirtualMachineDeleteInfo{Name: vm, RemovedVolumes: []string{}, KeptVolumes: []string{}, NvramRemoved: removeNvram, ManagedSaveRemoved: managedSave}
+	if CloudInitPath != "" {
+		ret.RemovedVolumes = append(ret.RemovedVolumes, CloudInitPath)
+	}
+	ret.KeptVolumes = append(ret.KeptVolumes, kept...)
+	for _, path := range volumes {
+		volume, err := libvirtInstance.LookupStorageVolByPath(path)
+		herr(err)
+		if err != nil {
+			continue
+		}
+
+		err = volume.Delete(0)
+		herr(err)
+		if err == nil {
+			ret.RemovedVolumes = append(ret.RemovedVolumes, path)
+		}
+		volume.Free()
+	}
+
+	hret(ret)
 }
 
 // VirtualMachineSoftReboot reboots a machine gracefully, as chosen by hypervisor.
-func VirtualMachineSoftReboot(vm string) {
+func VirtualMachineSoftReboot(vm string, mode string) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	flags, err := ParseRebootMode(mode)
+	herr(err)
+	if err != nil {
+		return
+	}
 
-	err = d.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+	err = d.Reboot(flags)
 	herr(err)
 
-	hok(fmt.Sprintf("%v was soft-rebooted successfully", vm))
+	hret(GetVirtualMachineStateInfo(vm))
 }
 
 // VirtualMachineHardReboot sends a VM into hard-reset mode. This is damaging to all ongoing file operations.
 func VirtualMachineHardReboot(vm string) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
 
 	err = d.Reset(0)
 	herr(err)
@@ -146,20 +932,50 @@ func VirtualMachineHardReboot(vm string) {
 }
 
 // VirtualMachineShutdown gracefully shuts down the VM.
-func VirtualMachineShutdown(vm string) {
+// With a non zero timeout it waits for the VM to go down and kills it if the guest ignores the request.
+func VirtualMachineShutdown(ctx context.Context, vm string, mode string, timeout time.Duration) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
 
-	err = d.Shutdown()
+	flags, err := ParseShutdownMode(mode)
 	herr(err)
+	if err != nil {
+		return
+	}
+
+	forced, err := ShutdownVirtualMachine(ctx, vm, d, flags, timeout)
+	switch {
+	// --timeout rather than the shutdown timeout elapsed, the guest may still be going down.
+	case errors.Is(err, errShutdownDeadline):
+		htimeout(fmt.Errorf("%v %v", vm, err))
+	case err != nil:
+		herr(err)
+		return
+	case timeout == 0:
+		hok(fmt.Sprintf("%v was shutdown successfully", vm))
+	case forced:
+		hok(fmt.Sprintf("%v ignored shutdown for %v and was shutoff forcefully", vm, timeout))
+	}
 
-	hok(fmt.Sprintf("%v was shutdown successfully", vm))
+	hok(fmt.Sprintf("%v was shutdown gracefully", vm))
 }
 
 // VirtualMachineShutoff kills running VM. Equivalent to pulling a plug out of a computer.
-func VirtualMachineShutoff(vm string) {
+func VirtualMachineShutoff(vm string, dryRun bool) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if dryRun {
+		hret(DryRunPlan{Action: "vm shutoff", Vms: []string{vm}, Volumes: []string{}, Steps: []string{"shut off " + vm}})
+	}
 
 	err = d.Destroy()
 	herr(err)
@@ -171,6 +987,10 @@ func VirtualMachineShutoff(vm string) {
 func VirtualMachineStart(vm string) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
 
 	//v.DomainRestore()
 	//_, err = v.DomainCreateWithFlags(d, uint32(libvirt.DomainStartBypassCache))
@@ -184,6 +1004,10 @@ func VirtualMachineStart(vm string) {
 func VirtualMachinePause(vm string) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
 
 	err = d.Suspend()
 	herr(err)
@@ -195,6 +1019,10 @@ func VirtualMachinePause(vm string) {
 func VirtualMachineResume(vm string) {
 	d, err := libvirtInstance.LookupDomainByName(vm)
 	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
 
 	err = d.Resume()
 	herr(err)
@@ -202,71 +1030,233 @@ func VirtualMachineResume(vm string) {
 	hok(fmt.Sprintf("%v was resumed", vm))
 }
 
-func VirtualMachinesIps() {
+// VirtualMachinesIps returns ip addresses of a VM, or of all running VMs on host when vm is empty.
+func VirtualMachinesIps(vm string) {
+	if vm != "" {
+		d, err := libvirtInstance.LookupDomainByName(vm)
+		herr(err)
+		if err != nil {
+			return
+		}
+		defer FreeDomain(d)()
 
-	var OutputString strings.Builder
+		hret(GetVirtualMachineIps(d))
+	}
 
 	AllDomains, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_RUNNING)
 	herr(err)
-	// fmt.Println(AllDomains)
-	fmt.Fprintf(&OutputString, "There are %d running domains:\n", len(AllDomains))
 
-	for _, domain := range AllDomains {
-		DomainName, err := domain.GetName()
-		fmt.Fprintf(&OutputString, "Domain - %s:\n", DomainName)
+	// each worker gets domains of its own, libvirt itself is fine with concurrent calls on one connection.
+	names := make([]string, len(AllDomains))
+	ips := make([][]VirtualMachineInterface, len(AllDomains))
+	ForEachParallel(len(AllDomains), *concurrency, func(i int) {
+		var err error
+		names[i], err = AllDomains[i].GetName()
 		herr(err)
 
-		AllDomainInterfaces, err := domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT)
-		herr(err)
-		// fmt.Printf("All interfaces for domain %s - %v, Type - %T\n", DomainName, AllDomainInterfaces, AllDomainInterfaces)
-		for _, DomainInterfaceEntry := range AllDomainInterfaces {
-			fmt.Fprintf(&OutputString, "interface - %v, address - ", DomainInterfaceEntry.Name)
-			for _, val := range DomainInterfaceEntry.Addrs {
-				fmt.Fprintf(&OutputString, val.Addr)
-				fmt.Fprintf(&OutputString, " ")
+		ips[i] = GetVirtualMachineIps(&AllDomains[i])
+		AllDomains[i].Free()
+	})
+
+	ret := make(map[string][]VirtualMachineInterface)
+	for i := range names {
+		ret[names[i]] = ips[i]
+	}
+
+	hret(ret)
+}
+
+func GetVirtualMachineIps(d *libvirt.Domain) []VirtualMachineInterface {
+	AllDomainInterfaces, err := GetVirtualMachineInterfaces(d, *ipSource)
+	herr(err)
+
+	return VirtualMachineIpsOf(AllDomainInterfaces)
+}
+
+// VirtualMachineIpsOf groups addresses of domain interfaces by ip version, leaving out loopback unless --include-loopback.
+func VirtualMachineIpsOf(AllDomainInterfaces []libvirt.DomainInterface) []VirtualMachineInterface {
+	ret := []VirtualMachineInterface{}
+	for _, DomainInterfaceEntry := range AllDomainInterfaces {
+		iface := VirtualMachineInterface{
+			Name: DomainInterfaceEntry.Name,
+			Mac:  DomainInterfaceEntry.Hwaddr,
+			Ipv4: []VirtualMachineAddress{},
+			Ipv6: []VirtualMachineAddress{},
+		}
+		for _, val := range DomainInterfaceEntry.Addrs {
+			if ip := net.ParseIP(val.Addr); ip != nil && ip.IsLoopback() && !*includeLoopback {
+				continue
+			}
+
+			if val.Type == libvirt.IP_ADDR_TYPE_IPV6 {
+				iface.Ipv6 = append(iface.Ipv6, VirtualMachineAddress{Addr: val.Addr, Prefix: val.Prefix, Type: "ipv6"})
+			} else {
+				iface.Ipv4 = append(iface.Ipv4, VirtualMachineAddress{Addr: val.Addr, Prefix: val.Prefix, Type: "ipv4"})
 			}
-			fmt.Fprintf(&OutputString, "\n")
-			// OutputString := fmt.Sprintf("interface - %v, address - %v", DomainInterfaceEntry.Name, AllAddrs.String())
-			// fmt.Printf("Domain - %s, interface - %v, ip address - %v\n",
-			//   DomainName, DomainInterfaceEntry.Name, DomainInterfaceEntry.Addrs[0].Addr)
 		}
-		domain.Free()
+
+		// the agent reports lo as well, it is noise unless asked for.
+		if len(DomainInterfaceEntry.Addrs) > 0 && len(iface.Ipv4)+len(iface.Ipv6) == 0 {
+			continue
+		}
+		ret = append(ret, iface)
+	}
+
+	return ret
+}
+
+// GetVirtualMachineInterfaces returns interface addresses of a VM from a given source: the guest agent,
+// DHCP leases of libvirt networks or the host ARP table. Without a source the agent is asked first,
+// falling back to leases for guests that do not run one.
+func GetVirtualMachineInterfaces(domain *libvirt.Domain, ipSource string) ([]libvirt.DomainInterface, error) {
+	switch ipSource {
+	case "agent":
+		return domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT)
+	case "lease":
+		return domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE)
+	case "arp":
+		return domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_ARP)
+	case "":
+		AllDomainInterfaces, err := domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT)
+		if err == nil {
+			return AllDomainInterfaces, nil
+		}
+		return domain.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE)
+	default:
+		return nil, fmt.Errorf("unknown ip source %v, use agent, lease or arp", ipSource)
 	}
-	fmt.Print(OutputString.String())
 }
 
-func VirtualMachinesStateAll() {
-	AllDomainsActive, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_ACTIVE)
+type VirtualMachineListEntry struct {
+	Name string
+	VirtualMachineStateInfo
+}
+
+// VirtualMachinesStateAll lists all vms, running ones first, optionally only those in given states.
+// The table shows names and states, the with flags add columns, json always has everything.
+func VirtualMachinesStateAll(states []string, withMemory bool, withCpu bool, withVcpus bool) {
+	filter, err := ParseStateFilter(states)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	AllDomainsActive, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_ACTIVE | filter)
 	herr(err)
-	AllDomainsInactiv, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_INACTIVE)
+	AllDomainsInactiv, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_INACTIVE | filter)
 	herr(err)
 
-	OutputString := fmt.Sprintf("There are %d domains: %d active and %d inactive",
-		len(AllDomainsActive)+len(AllDomainsInactiv), len(AllDomainsActive), len(AllDomainsInactiv))
-	fmt.Println(OutputString)
-	PrintVirtualMachinesStatus(AllDomainsActive)
-	PrintVirtualMachinesStatus(AllDomainsInactiv)
+	names := []string{}
+	for _, domain := range append(AllDomainsActive, AllDomainsInactiv...) {
+		DomainName, err := domain.GetName()
+		herr(err)
+		domain.Free()
+		if err == nil {
+			names = append(names, DomainName)
+		}
+	}
+
+	// every worker looks its vm up by name, so no domain handle is shared between goroutines.
+	ret := make([]VirtualMachineListEntry, len(names))
+	ForEachParallel(len(names), *concurrency, func(i int) {
+		ret[i] = VirtualMachineListEntry{Name: names[i], VirtualMachineStateInfo: GetVirtualMachineStateInfo(names[i])}
+	})
+
+	header := []string{"NAME", "STATE"}
+	if withMemory {
+		header = append(header, "MEMORY", "MAX MEMORY")
+	}
+	if withCpu {
+		header = append(header, "CPU TIME")
+	}
+	if withVcpus {
+		header = append(header, "VCPUS")
+	}
+
+	rows := [][]string{}
+	for _, entry := range ret {
+		row := []string{entry.Name, string(entry.State)}
+		if withMemory {
+			row = append(row, fmt.Sprint(entry.MemoryBytes), fmt.Sprint(entry.MaxMemoryBytes))
+		}
+		if withCpu {
+			row = append(row, fmt.Sprint(time.Duration(entry.CpuTime)))
+		}
+		if withVcpus {
+			row = append(row, fmt.Sprint(entry.CpuCount))
+		}
+		rows = append(rows, row)
+	}
 
+	// scripts parse the text listing --show-all always printed, json and table have to be asked for.
+	if !FlagGiven("format") {
+		fmt.Printf("There are %d domains: %d active and %d inactive\n", len(AllDomainsActive)+len(AllDomainsInactiv), len(AllDomainsActive), len(AllDomainsInactiv))
+		for _, row := range rows {
+			fmt.Printf("%-30v %-15v\n", row[0], strings.Join(row[1:], " "))
+		}
+		hexit(0)
+	}
+
+	htable(ret, header, rows)
 }
 
-func PrintVirtualMachinesStatus(domains []libvirt.Domain) {
-	for _, domain := range domains {
-		DomainName, err := domain.GetName()
-		herr(err)
-		VmState := GetVirtualMachineStateInfo(DomainName)
-		fmt.Printf("%-30v %-15v\n", DomainName, VmState.State)
+var stateFilters = map[string]libvirt.ConnectListAllDomainsFlags{
+	"running": libvirt.CONNECT_LIST_DOMAINS_RUNNING,
+	"paused":  libvirt.CONNECT_LIST_DOMAINS_PAUSED,
+	"shutoff": libvirt.CONNECT_LIST_DOMAINS_SHUTOFF,
+	"other":   libvirt.CONNECT_LIST_DOMAINS_OTHER,
+}
+
+// ParseStateFilter converts states given to --filter-state into ListAllDomains flags, a domain in any of them matches.
+func ParseStateFilter(states []string) (libvirt.ConnectListAllDomainsFlags, error) {
+	var filter libvirt.ConnectListAllDomainsFlags
+	for _, state := range states {
+		flag, ok := stateFilters[strings.TrimSpace(state)]
+		if !ok {
+			return 0, fmt.Errorf("unknown state %v to filter by, use running, paused, shutoff or other", state)
+		}
+		filter |= flag
 	}
+	return filter, nil
 }
 
-func GetVirtualMachineStateInfo(vm string) (info VirtualMachineStateInfo) {
+func GetVirtualMachineStateInfo(vm string) VirtualMachineStateInfo {
+	VmStateInfo, err := ReadVirtualMachineStateInfo(vm)
+	herr(err)
+	return VmStateInfo
+}
 
+// ReadVirtualMachineStateInfo is GetVirtualMachineStateInfo returning the first error instead of logging it,
+// for results that carry errors themselves.
+func ReadVirtualMachineStateInfo(vm string) (VirtualMachineStateInfo, error) {
 	var VmStateInfo VirtualMachineStateInfo
 
 	d, err := libvirtInstance.LookupDomainByName(vm)
-	herr(err)
+	if err != nil {
+		return VmStateInfo, err
+	}
+	defer d.Free()
+
+	if VmStateInfo.Uuid, err = d.GetUUIDString(); err != nil {
+		return VmStateInfo, err
+	}
+
+	// inactive domains have no id, libvirt reports it as an error.
+	VmStateInfo.Id = -1
+	if id, err := d.GetID(); err == nil {
+		VmStateInfo.Id = int(id)
+	}
 
 	dominfo, err := d.GetInfo()
-	herr(err)
+	if err != nil {
+		return VmStateInfo, err
+	}
+
+	state, reason, err := d.GetState()
+	if err != nil {
+		return VmStateInfo, err
+	}
+	VmStateInfo.StateReason = VirtualMachineStateReason(state, reason)
 
 	VmStateInfo.CpuCount = dominfo.NrVirtCpu
 	VmStateInfo.CpuTime = dominfo.CpuTime
@@ -274,51 +1264,378 @@ func GetVirtualMachineStateInfo(vm string) (info VirtualMachineStateInfo) {
 	VmStateInfo.MemoryBytes = dominfo.Memory * 1024
 	VmStateInfo.MaxMemoryBytes = dominfo.MaxMem * 1024
 
-	switch dominfo.State {
-	case libvirt.DOMAIN_NOSTATE:
-		VmStateInfo.State = VirtStatePending
+	VmStateInfo.State = VirtualMachineStatusOf(dominfo.State)
+
+	if active, err := d.IsActive(); err == nil && active {
+		VmStateInfo.UptimeSeconds, VmStateInfo.UptimeSource = GetVirtualMachineUptime(vm, dominfo)
+	}
+
+	return VmStateInfo, nil
+}
+
+// VirtualMachineStatusOf names a libvirt domain state.
+func VirtualMachineStatusOf(state libvirt.DomainState) VirtualMachineStatus {
+	switch state {
 	case libvirt.DOMAIN_RUNNING:
-		VmStateInfo.State = VirtStateRunning
+		return VirtStateRunning
 	case libvirt.DOMAIN_BLOCKED:
-		VmStateInfo.State = VirtStateBlocked
+		return VirtStateBlocked
 	case libvirt.DOMAIN_PAUSED:
-		VmStateInfo.State = VirtStatePaused
+		return VirtStatePaused
 	case libvirt.DOMAIN_SHUTDOWN:
-		VmStateInfo.State = VirtStateShutdown
+		return VirtStateShutdown
 	case libvirt.DOMAIN_SHUTOFF:
-		VmStateInfo.State = VirtStateShutoff
+		return VirtStateShutoff
 	case libvirt.DOMAIN_CRASHED:
-		VmStateInfo.State = VirtStateCrashed
+		return VirtStateCrashed
 	case libvirt.DOMAIN_PMSUSPENDED:
-		VmStateInfo.State = VirtStateHybernating
+		return VirtStateHybernating
 	}
+	return VirtStatePending
+}
 
-	return VmStateInfo
+// LookupVirtualMachine finds a domain by uuid, numeric id or name, in that order of preference.
+// Empty uuid and negative id are ignored.
+func LookupVirtualMachine(vm string, uuid string, id int) (*libvirt.Domain, error) {
+	switch {
+	case uuid != "":
+		return libvirtInstance.LookupDomainByUUIDString(uuid)
+	case id >= 0:
+		return libvirtInstance.LookupDomainById(uint32(id))
+	default:
+		return libvirtInstance.LookupDomainByName(vm)
+	}
+}
+
+// ResolveVirtualMachineName returns the name of a domain addressed by any of its identifiers,
+// so every command can keep working with names.
+func ResolveVirtualMachineName(vm string, uuid string, id int) string {
+	d, err := LookupVirtualMachine(vm, uuid, id)
+	herr(err)
+	if err != nil {
+		return vm
+	}
+	defer d.Free()
+
+	name, err := d.GetName()
+	herr(err)
+
+	return name
 }
 
-func LibvirtInit() {
+// eventLoopRunning is set by RunEventLoop, connections then also get keepalive.
+var eventLoopRunning bool
+
+// RunEventLoop registers the default libvirt event loop and runs it in the background. Long running commands need it
+// for events and for keepalive, a connection only uses it when it is registered before the connection is opened.
+func RunEventLoop() {
+	err := libvirt.EventRegisterDefaultImpl()
+	herr(err)
+	if err != nil {
+		return
+	}
+	eventLoopRunning = true
+
+	go func() {
+		for {
+			err := libvirt.EventRunDefaultImpl()
+			herr(err)
+		}
+	}()
+}
+
+// OpenConnection connects to --connect, read only with --readonly. With an event loop running the connection also
+// sends keepalive messages, so a peer that went away is noticed and the connection closed.
+func OpenConnection() (*libvirt.Connect, error) {
+	var conn *libvirt.Connect
 	var err error
-	libvirtInstance, err = libvirt.NewConnect("qemu:///system")
+	if *readonly {
+		conn, err = libvirt.NewConnectReadOnly(*connectUri)
+	} else {
+		conn, err = libvirt.NewConnect(*connectUri)
+	}
+	if err != nil || !eventLoopRunning || *keepaliveInterval <= 0 {
+		return conn, err
+	}
+
+	if err = conn.SetKeepAlive(*keepaliveInterval, *keepaliveCount); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+var connectionMu sync.RWMutex
+
+// EnsureConnection reconnects when the connection died, e.g. the daemon restarted or keepalive gave up on it.
+// Callers hold connectionMu for reading while they use the connection, so it is not swapped under them.
+func EnsureConnection() error {
+	connectionMu.RLock()
+	alive, err := libvirtInstance.IsAlive()
+	connectionMu.RUnlock()
+	if err == nil && alive {
+		return nil
+	}
+
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+
+	// another caller may have reconnected in the meantime.
+	if alive, err := libvirtInstance.IsAlive(); err == nil && alive {
+		return nil
+	}
+
+	Log(LevelWarn, "connection is dead, reconnecting", "uri", *connectUri)
+	libvirtInstance.Close()
+	conn, err := OpenConnection()
 	if err != nil {
-		log.Fatalf("failed to connect: %v", err)
+		return err
+	}
+	libvirtInstance = conn
+	return nil
+}
+
+// LibvirtInit opens the connection. An unreachable remote host can take minutes to fail,
+// so it gives up once the context is done.
+func LibvirtInit(ctx context.Context) {
+	connected := make(chan error, 1)
+	go func() {
+		var err error
+		libvirtInstance, err = OpenConnection()
+		connected <- err
+	}()
+
+	start := time.Now()
+	select {
+	case err := <-connected:
+		if err != nil {
+			hfail(fmt.Errorf("failed to connect to %v: %v", *connectUri, err))
+		}
+		Log(LevelDebug, "connected", "uri", *connectUri, "readonly", *readonly, "duration", time.Since(start))
+	case <-ctx.Done():
+		htimeout(fmt.Errorf("connecting to %v timed out after %v", *connectUri, *timeout))
+	}
+}
+
+// deadlineGrace is how long past --timeout commands that wait get to report where they stopped.
+const deadlineGrace = 5 * time.Second
+
+// ExitAfterDeadline ends the process when a command is still busy after --timeout, e.g. stuck in a libvirt call
+// to a host that went away.
+func ExitAfterDeadline(ctx context.Context) {
+	<-ctx.Done()
+	time.Sleep(deadlineGrace)
+	htimeout(fmt.Errorf("did not finish within --timeout %v", *timeout))
+}
+
+// ModificationImpact translates --live and --persistent into libvirt flags.
+func ModificationImpact() libvirt.DomainModificationImpact {
+	flags := libvirt.DOMAIN_AFFECT_CURRENT
+	if *live {
+		flags |= libvirt.DOMAIN_AFFECT_LIVE
+	}
+	if *persistent {
+		flags |= libvirt.DOMAIN_AFFECT_CONFIG
+	}
+	return flags
+}
+
+// QueryImpact is ModificationImpact for reading settings back. libvirt reads a single state at a time,
+// so with both --live and --persistent the running one is shown.
+func QueryImpact() libvirt.DomainModificationImpact {
+	switch {
+	case *live:
+		return libvirt.DOMAIN_AFFECT_LIVE
+	case *persistent:
+		return libvirt.DOMAIN_AFFECT_CONFIG
+	}
+	return libvirt.DOMAIN_AFFECT_CURRENT
+}
+
+// QueryXMLFlags is QueryImpact for reading the domain xml, the persistent one is shown with --persistent alone.
+func QueryXMLFlags() libvirt.DomainXMLFlags {
+	if *persistent && !*live {
+		return libvirt.DOMAIN_XML_INACTIVE
+	}
+	return 0
+}
+
+// DeviceModifyFlags is ModificationImpact for device attach, detach and update calls.
+func DeviceModifyFlags() libvirt.DomainDeviceModifyFlags {
+	return libvirt.DomainDeviceModifyFlags(ModificationImpact())
+}
+
+// DeviceScope is one state of a domain a device change applies to, with the xml flags to read that state.
+type DeviceScope struct {
+	Modify libvirt.DomainDeviceModifyFlags
+	XML    libvirt.DomainXMLFlags
+}
+
+// DeviceScopes splits DeviceModifyFlags into the states it affects, so a change that depends on the devices already
+// there can read and modify each state on its own. Without --live and --persistent that is the running state of a
+// running vm, the persistent one otherwise.
+func DeviceScopes(d *libvirt.Domain) ([]DeviceScope, error) {
+	affectLive, affectConfig := *live, *persistent
+	if !affectLive && !affectConfig {
+		active, err := d.IsActive()
+		if err != nil {
+			return nil, err
+		}
+		affectLive, affectConfig = active, !active
+	}
+
+	var scopes []DeviceScope
+	if affectLive {
+		scopes = append(scopes, DeviceScope{Modify: libvirt.DOMAIN_DEVICE_MODIFY_LIVE})
+	}
+	if affectConfig {
+		scopes = append(scopes, DeviceScope{Modify: libvirt.DOMAIN_DEVICE_MODIFY_CONFIG, XML: libvirt.DOMAIN_XML_INACTIVE})
+	}
+	return scopes, nil
+}
+
+// ChangedString returns the value of a string flag, or nil when the flag was not passed at all.
+// Used by commands where an empty value has a meaning of its own.
+func ChangedString(name string) *string {
+	if !pflag.CommandLine.Changed(name) {
+		return nil
 	}
+
+	value, err := pflag.CommandLine.GetString(name)
+	herr(err)
+	return &value
+}
+
+// ChangedInt64 is ChangedString for int64 flags, where zero is a valid value.
+func ChangedInt64(name string) *int64 {
+	if !pflag.CommandLine.Changed(name) {
+		return nil
+	}
+
+	value, err := pflag.CommandLine.GetInt64(name)
+	herr(err)
+	return &value
+}
+
+// ChangedUint64 is ChangedString for uint64 flags, where zero is a valid value.
+func ChangedUint64(name string) *uint64 {
+	if !pflag.CommandLine.Changed(name) {
+		return nil
+	}
+
+	value, err := pflag.CommandLine.GetUint64(name)
+	herr(err)
+	return &value
+}
+
+// ChangedUint is ChangedString for uint flags, where zero is a valid value.
+func ChangedUint(name string) *uint {
+	if !pflag.CommandLine.Changed(name) {
+		return nil
+	}
+
+	value, err := pflag.CommandLine.GetUint(name)
+	herr(err)
+	return &value
+}
+
+// ChangedBool is ChangedString for bool flags, where false is a request of its own, e.g. --secure-boot=false.
+func ChangedBool(name string) *bool {
+	if !pflag.CommandLine.Changed(name) {
+		return nil
+	}
+
+	value, err := pflag.CommandLine.GetBool(name)
+	herr(err)
+	return &value
 }
 
+// IsLibvirtError reports whether err is a libvirt error with a given code.
+func IsLibvirtError(err error, code libvirt.ErrorNumber) bool {
+	var lerr libvirt.Error
+	return errors.As(err, &lerr) && lerr.Code == code
+}
+
+// errorReported is set once herr logged an error, so a command that gave up without a result exits with 1.
+var errorReported bool
+
+// herr logs an error to stderr, stdout is kept for results.
 func herr(e error) {
 	if e != nil {
-		fmt.Printf("%v\n", strings.ReplaceAll(e.Error(), "\"", ""))
-		// os.Exit(1)
+		errorReported = true
+		Log(LevelError, e.Error())
 	}
 }
 
+// FreeDomain frees a domain handle once, either when the returned func is called or when the process ends through hexit.
+// Commands print their result through hret and the like, which exit without running deferred calls, so they free with
+// defer FreeDomain(d)().
+func FreeDomain(d *libvirt.Domain) func() {
+	freed := false
+	free := func() {
+		if !freed {
+			freed = true
+			d.Free()
+		}
+	}
+	OnExit(free)
+	return free
+}
+
+// hfail reports an error as json and exits, for invocations that cannot go any further, e.g. conflicting flags.
+func hfail(e error) {
+	ret, _ := json.Marshal(map[string]string{"error": e.Error()})
+	fmt.Println(string(ret))
+	hexit(1)
+}
+
+// ExitTimeout is the exit code once --timeout elapses, the same timeout(1) uses, so callers can tell a slow or unreachable host from a failure.
+const ExitTimeout = 124
+
+// htimeout reports as json that --timeout elapsed and exits with ExitTimeout.
+func htimeout(e error) {
+	ret, _ := json.Marshal(map[string]string{"error": e.Error()})
+	fmt.Println(string(ret))
+	hexit(ExitTimeout)
+}
+
 func hok(message string) {
-	fmt.Printf(`{"ok":"%v"}`, strings.ReplaceAll(message, "\"", ""))
-	os.Exit(0)
+	fmt.Print(string(htimed([]byte(fmt.Sprintf(`{"ok":"%v"}`, strings.ReplaceAll(message, "\"", ""))))))
+	hexit(0)
 }
 
 func hret(i any) {
 	ret, err := json.Marshal(i)
 	herr(err)
-	fmt.Print(string(ret))
-	os.Exit(0)
+	fmt.Print(string(htimed(ret)))
+	hexit(0)
+}
+
+// htimed adds DurationMs of the operation to a json object result with --timing. Other results are left as they are,
+// the duration is logged at info level either way.
+func htimed(ret []byte) []byte {
+	if !*timing || operation.Name == "" || len(ret) < 2 || ret[0] != '{' {
+		return ret
+	}
+
+	duration := fmt.Sprintf(`"DurationMs":%v`, time.Since(operation.Start).Milliseconds())
+	if len(ret) == 2 {
+		return []byte("{" + duration + "}")
+	}
+	return []byte(string(ret[:len(ret)-1]) + "," + duration + "}")
+}
+
+// htable prints the result of a listing command either as json, or as a table when asked by --format.
+func htable(i any, header []string, rows [][]string) {
+	if *format != "table" {
+		hret(i)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	hexit(0)
 }
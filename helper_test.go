@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"libvirt.org/go/libvirt"
+)
+
+func TestParseStateFilter(t *testing.T) {
+	tests := []struct {
+		states  []string
+		want    libvirt.ConnectListAllDomainsFlags
+		wantErr bool
+	}{
+		{states: nil, want: 0},
+		{states: []string{"running"}, want: libvirt.CONNECT_LIST_DOMAINS_RUNNING},
+		{states: []string{"paused", " shutoff "}, want: libvirt.CONNECT_LIST_DOMAINS_PAUSED | libvirt.CONNECT_LIST_DOMAINS_SHUTOFF},
+		{states: []string{"running", "running"}, want: libvirt.CONNECT_LIST_DOMAINS_RUNNING},
+		{states: []string{"other"}, want: libvirt.CONNECT_LIST_DOMAINS_OTHER},
+		{states: []string{"running", "stopped"}, wantErr: true},
+		{states: []string{""}, wantErr: true},
+		{states: []string{"Running"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStateFilter(tt.states)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStateFilter(%q) error = %v, want error %v", tt.states, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseStateFilter(%q) = %v, want %v", tt.states, got, tt.want)
+		}
+	}
+}
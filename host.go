@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type HostNodeInfo struct {
+	Hostname    string
+	Hypervisor  string
+	CpuModel    string
+	MemoryBytes uint64
+	Cpus        uint
+	MHz         uint
+	NumaNodes   uint32
+	// SocketsPerNode is 1 when the numa topology is too unusual for libvirt to tell, all cpus then show up as cores.
+	SocketsPerNode uint32
+	Cores          uint32 // per socket
+	Threads        uint32 // per core
+}
+
+// HostInfo returns cpu and memory of the host, to size VMs against.
+func HostInfo() {
+	NodeInfo, err := libvirtInstance.GetNodeInfo()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := HostNodeInfo{
+		CpuModel:       NodeInfo.Model,
+		MemoryBytes:    NodeInfo.Memory * 1024,
+		Cpus:           NodeInfo.Cpus,
+		MHz:            NodeInfo.MHz,
+		NumaNodes:      NodeInfo.Nodes,
+		SocketsPerNode: NodeInfo.Sockets,
+		Cores:          NodeInfo.Cores,
+		Threads:        NodeInfo.Threads,
+	}
+
+	ret.Hostname, err = libvirtInstance.GetHostname()
+	herr(err)
+	ret.Hypervisor, err = libvirtInstance.GetType()
+	herr(err)
+
+	htable(ret, []string{"HOSTNAME", "HYPERVISOR", "CPU", "CPUS", "MHZ", "NUMA", "SOCKETS/NODE", "CORES", "THREADS", "MEMORY"},
+		[][]string{{ret.Hostname, ret.Hypervisor, ret.CpuModel, fmt.Sprint(ret.Cpus), fmt.Sprint(ret.MHz), fmt.Sprint(ret.NumaNodes),
+			fmt.Sprint(ret.SocketsPerNode), fmt.Sprint(ret.Cores), fmt.Sprint(ret.Threads), fmt.Sprint(ret.MemoryBytes)}})
+}
+
+type HostMemory struct {
+	TotalBytes   uint64
+	FreeBytes    uint64
+	BuffersBytes uint64
+	CachedBytes  uint64
+	Cells        []HostMemoryCell
+}
+
+type HostMemoryCell struct {
+	Cell       int
+	TotalBytes uint64
+	FreeBytes  uint64
+}
+
+// HostMemoryInfo returns total and free memory of the host, and of each numa cell.
+// A host without numa shows up as a single cell holding all of the memory.
+func HostMemoryInfo() {
+	stats, err := libvirtInstance.GetMemoryStats(libvirt.NODE_MEMORY_STATS_ALL_CELLS, 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	// memory stats are in KiB.
+	ret := HostMemory{
+		TotalBytes:   stats.Total * 1024,
+		FreeBytes:    stats.Free * 1024,
+		BuffersBytes: stats.Buffers * 1024,
+		CachedBytes:  stats.Cached * 1024,
+		Cells:        []HostMemoryCell{},
+	}
+
+	NodeInfo, err := libvirtInstance.GetNodeInfo()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	for cell := 0; cell < int(NodeInfo.Nodes); cell++ {
+		CellStats, err := libvirtInstance.GetMemoryStats(cell, 0)
+		if err != nil {
+			break
+		}
+		ret.Cells = append(ret.Cells, HostMemoryCell{Cell: cell, TotalBytes: CellStats.Total * 1024, FreeBytes: CellStats.Free * 1024})
+	}
+	if len(ret.Cells) == 0 {
+		ret.Cells = append(ret.Cells, HostMemoryCell{Cell: 0, TotalBytes: ret.TotalBytes, FreeBytes: ret.FreeBytes})
+	}
+
+	var rows [][]string
+	for _, cell := range ret.Cells {
+		rows = append(rows, []string{fmt.Sprint(cell.Cell), fmt.Sprint(cell.TotalBytes), fmt.Sprint(cell.FreeBytes)})
+	}
+	htable(ret, []string{"CELL", "TOTAL", "FREE"}, rows)
+}
+
+type HostCpuStats struct {
+	// Time is when the counters were read, to compute utilization from two samples.
+	Time time.Time
+	Cpus []HostCpuTimes
+}
+
+type HostCpuTimes struct {
+	Cpu      string // "all" for the whole host
+	UserNs   uint64
+	KernelNs uint64
+	IdleNs   uint64
+	IowaitNs uint64
+}
+
+// HostCpuStatistics returns cumulative cpu times of the host, and of each of its cpus with perCpu.
+func HostCpuStatistics(perCpu bool) {
+	ret := HostCpuStats{Time: time.Now(), Cpus: []HostCpuTimes{}}
+
+	stats, err := libvirtInstance.GetCPUStats(int(libvirt.NODE_CPU_STATS_ALL_CPUS), 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	ret.Cpus = append(ret.Cpus, HostCpuTimes{Cpu: "all", UserNs: stats.User, KernelNs: stats.Kernel, IdleNs: stats.Idle, IowaitNs: stats.Iowait})
+
+	if perCpu {
+		NodeInfo, err := libvirtInstance.GetNodeInfo()
+		herr(err)
+		if err != nil {
+			return
+		}
+
+		for cpu := 0; cpu < int(NodeInfo.Cpus); cpu++ {
+			stats, err := libvirtInstance.GetCPUStats(cpu, 0)
+			herr(err)
+			if err != nil {
+				continue
+			}
+			ret.Cpus = append(ret.Cpus, HostCpuTimes{Cpu: fmt.Sprint(cpu), UserNs: stats.User, KernelNs: stats.Kernel, IdleNs: stats.Idle, IowaitNs: stats.Iowait})
+		}
+	}
+
+	var rows [][]string
+	for _, cpu := range ret.Cpus {
+		rows = append(rows, []string{cpu.Cpu, fmt.Sprint(cpu.UserNs), fmt.Sprint(cpu.KernelNs), fmt.Sprint(cpu.IdleNs), fmt.Sprint(cpu.IowaitNs)})
+	}
+	htable(ret, []string{"CPU", "USER", "KERNEL", "IDLE", "IOWAIT"}, rows)
+}
+
+// Version of the helper, set at build time with -ldflags "-X main.Version=v1.2.3".
+var Version = "dev"
+
+type HelperVersion struct {
+	Helper            string
+	Libvirt           string
+	Hypervisor        string
+	HypervisorVersion string
+}
+
+// HelperVersions returns versions of the helper, the libvirt library it talks through and the hypervisor behind it.
+func HelperVersions() {
+	ret := HelperVersion{Helper: Version}
+
+	LibVersion, err := libvirtInstance.GetLibVersion()
+	herr(err)
+	ret.Libvirt = FormatLibvirtVersion(LibVersion)
+
+	ret.Hypervisor, err = libvirtInstance.GetType()
+	herr(err)
+
+	// drivers without a hypervisor, e.g. test:///default, report 0.
+	if HypervisorVersion, err := libvirtInstance.GetVersion(); err == nil && HypervisorVersion != 0 {
+		ret.HypervisorVersion = FormatLibvirtVersion(HypervisorVersion)
+	}
+
+	hret(ret)
+}
+
+// FormatLibvirtVersion turns a version libvirt encodes as major * 1,000,000 + minor * 1,000 + release into 10.1.0.
+func FormatLibvirtVersion(version uint32) string {
+	return fmt.Sprintf("%v.%v.%v", version/1000000, version/1000%1000, version%1000)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineJob struct {
+	Active             bool
+	Type               string
+	Operation          string
+	ElapsedMs          uint64
+	RemainingMs        uint64
+	DataTotalBytes     uint64
+	DataProcessedBytes uint64
+	DataRemainingBytes uint64
+	Error              string
+	BlockJobs          []VirtualMachineBlockJob
+}
+
+var jobTypes = map[libvirt.DomainJobType]string{
+	libvirt.DOMAIN_JOB_NONE:      "none",
+	libvirt.DOMAIN_JOB_BOUNDED:   "bounded",
+	libvirt.DOMAIN_JOB_UNBOUNDED: "unbounded",
+	libvirt.DOMAIN_JOB_COMPLETED: "completed",
+	libvirt.DOMAIN_JOB_FAILED:    "failed",
+	libvirt.DOMAIN_JOB_CANCELLED: "cancelled",
+}
+
+var jobOperations = map[libvirt.DomainJobOperationType]string{
+	libvirt.DOMAIN_JOB_OPERATION_UNKNOWN:         "unknown",
+	libvirt.DOMAIN_JOB_OPERATION_START:           "start",
+	libvirt.DOMAIN_JOB_OPERATION_SAVE:            "save",
+	libvirt.DOMAIN_JOB_OPERATION_RESTORE:         "restore",
+	libvirt.DOMAIN_JOB_OPERATION_MIGRATION_IN:    "migration-in",
+	libvirt.DOMAIN_JOB_OPERATION_MIGRATION_OUT:   "migration-out",
+	libvirt.DOMAIN_JOB_OPERATION_SNAPSHOT:        "snapshot",
+	libvirt.DOMAIN_JOB_OPERATION_SNAPSHOT_REVERT: "snapshot-revert",
+	libvirt.DOMAIN_JOB_OPERATION_DUMP:            "dump",
+	libvirt.DOMAIN_JOB_OPERATION_BACKUP:          "backup",
+	libvirt.DOMAIN_JOB_OPERATION_SNAPSHOT_DELETE: "snapshot-delete",
+}
+
+// VirtualMachineJobInfo returns progress of a long running job of a VM, like a migration, save, dump or backup,
+// together with block jobs running on its disks. Active is false when the VM has no job at the moment.
+func VirtualMachineJobInfo(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	ret, err := GetVirtualMachineJobInfo(d)
+	herr(err)
+
+	ret.BlockJobs, err = GetVirtualMachineBlockJobs(d)
+	herr(err)
+
+	hret(ret)
+}
+
+func GetVirtualMachineJobInfo(d *libvirt.Domain) (VirtualMachineJob, error) {
+	var JobInfo VirtualMachineJob
+
+	stats, err := d.GetJobStats(0)
+	if err != nil {
+		return JobInfo, err
+	}
+
+	JobInfo.Type = jobTypes[stats.Type]
+	JobInfo.Active = stats.Type == libvirt.DOMAIN_JOB_BOUNDED || stats.Type == libvirt.DOMAIN_JOB_UNBOUNDED
+	if stats.OperationSet {
+		JobInfo.Operation = jobOperations[stats.Operation]
+	}
+	JobInfo.ElapsedMs = stats.TimeElapsed
+	JobInfo.RemainingMs = stats.TimeRemaining
+	JobInfo.DataTotalBytes = stats.DataTotal
+	JobInfo.DataProcessedBytes = stats.DataProcessed
+	JobInfo.DataRemainingBytes = stats.DataRemaining
+	JobInfo.Error = stats.ErrorMessage
+
+	return JobInfo, nil
+}
+
+// VirtualMachineJobAbort cancels a long running job of a VM, e.g. a stuck migration.
+func VirtualMachineJobAbort(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = d.AbortJob()
+	// libvirt reports a missing job as an invalid operation, which is not worth failing over.
+	if IsLibvirtError(err, libvirt.ERR_OPERATION_INVALID) {
+		hok(fmt.Sprintf("%v has no active job", vm))
+	}
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("job of %v was aborted", vm))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// linuxKeycodes maps key names to linux input event codes, see linux/input-event-codes.h.
+// Only keys someone would realistically send to a login screen or a bootloader are here,
+// anything else can be passed as a raw code.
+var linuxKeycodes = map[string]uint{
+	"esc": 1, "1": 2, "2": 3, "3": 4, "4": 5, "5": 6, "6": 7, "7": 8, "8": 9, "9": 10, "0": 11,
+	"minus": 12, "equal": 13, "backspace": 14, "tab": 15,
+	"q": 16, "w": 17, "e": 18, "r": 19, "t": 20, "y": 21, "u": 22, "i": 23, "o": 24, "p": 25,
+	"leftbrace": 26, "rightbrace": 27, "enter": 28, "leftctrl": 29,
+	"a": 30, "s": 31, "d": 32, "f": 33, "g": 34, "h": 35, "j": 36, "k": 37, "l": 38,
+	"semicolon": 39, "apostrophe": 40, "grave": 41, "leftshift": 42, "backslash": 43,
+	"z": 44, "x": 45, "c": 46, "v": 47, "b": 48, "n": 49, "m": 50,
+	"comma": 51, "dot": 52, "slash": 53, "rightshift": 54, "kpasterisk": 55, "leftalt": 56, "space": 57, "capslock": 58,
+	"f1": 59, "f2": 60, "f3": 61, "f4": 62, "f5": 63, "f6": 64, "f7": 65, "f8": 66, "f9": 67, "f10": 68,
+	"numlock": 69, "scrolllock": 70, "f11": 87, "f12": 88,
+	"rightctrl": 97, "sysrq": 99, "rightalt": 100,
+	"home": 102, "up": 103, "pageup": 104, "left": 105, "right": 106, "end": 107, "down": 108, "pagedown": 109,
+	"insert": 110, "delete": 111, "pause": 119, "leftmeta": 125, "rightmeta": 126,
+
+	// aliases
+	"ctrl": 29, "alt": 56, "shift": 42, "meta": 125, "super": 125, "win": 125,
+	"escape": 1, "return": 28, "del": 111, "ins": 110, "altgr": 100, "printscreen": 99,
+}
+
+// ParseKeycodes converts a comma separated list of key names (e.g. "ctrl,alt,delete", "KEY_F2")
+// or raw linux keycodes into keycodes.
+func ParseKeycodes(keys string) ([]uint, error) {
+	var keycodes []uint
+
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		key = strings.TrimPrefix(key, "key_")
+
+		if code, ok := linuxKeycodes[key]; ok {
+			keycodes = append(keycodes, code)
+			continue
+		}
+
+		code, err := strconv.ParseUint(key, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown key %v", key)
+		}
+		keycodes = append(keycodes, uint(code))
+	}
+
+	return keycodes, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeycodes(t *testing.T) {
+	tests := []struct {
+		keys    string
+		want    []uint
+		wantErr bool
+	}{
+		{keys: "ctrl,alt,delete", want: []uint{29, 56, 111}},
+		{keys: "KEY_LEFTCTRL, KEY_F2", want: []uint{29, 60}},
+		{keys: "Enter", want: []uint{28}},
+		{keys: "super,l", want: []uint{125, 38}},
+		// single digits are key names, a raw code below 10 has to be given in hex.
+		{keys: "1", want: []uint{2}},
+		{keys: "28", want: []uint{28}},
+		{keys: "0x1", want: []uint{1}},
+		{keys: "0x1c", want: []uint{28}},
+		{keys: "ctrl,nosuchkey", wantErr: true},
+		{keys: "", wantErr: true},
+		{keys: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseKeycodes(tt.keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseKeycodes(%q) error = %v, want error %v", tt.keys, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseKeycodes(%q) = %v, want %v", tt.keys, got, tt.want)
+		}
+	}
+}
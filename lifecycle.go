@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+var virtualMachineStatuses = []VirtualMachineStatus{
+	VirtStatePending, VirtStateRunning, VirtStateBlocked, VirtStatePaused,
+	VirtStateShutdown, VirtStateShutoff, VirtStateCrashed, VirtStateHybernating,
+}
+
+// VirtualMachineWaitFor blocks until a VM reaches the given state, or the context is done,
+// in which case the process exits with ExitTimeout.
+func VirtualMachineWaitFor(ctx context.Context, vm string, state string) {
+	valid := false
+	for _, status := range virtualMachineStatuses {
+		if string(status) == state {
+			valid = true
+		}
+	}
+	if !valid {
+		herr(fmt.Errorf("unknown state %v", state))
+		hexit(1)
+	}
+
+	VmState, reached := WaitForVirtualMachineState(ctx, vm, VirtualMachineStatus(state))
+	if !reached {
+		htimeout(fmt.Errorf("%v did not become %v within %v, it is %v", vm, state, *timeout, VmState.State))
+	}
+
+	hret(VmState)
+}
+
+// WaitForVirtualMachineState polls the state of a VM with an exponential backoff until it matches,
+// or the context is done. A transient VM is gone once it shuts down, that counts as shutoff.
+func WaitForVirtualMachineState(ctx context.Context, vm string, state VirtualMachineStatus) (VirtualMachineStateInfo, bool) {
+	const maxBackoff = 5 * time.Second
+
+	backoff := 250 * time.Millisecond
+
+	for {
+		VmState, err := ReadVirtualMachineStateInfo(vm)
+		if state == VirtStateShutoff && IsLibvirtError(err, libvirt.ERR_NO_DOMAIN) {
+			return VirtualMachineStateInfo{Id: -1, State: VirtStateShutoff}, true
+		}
+		herr(err)
+		if VmState.State == state {
+			return VmState, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return VmState, false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+type VirtualMachineEvent struct {
+	Time   time.Time
+	Domain string
+	Event  string
+	Detail string
+}
+
+// VirtualMachineWatchEvents prints lifecycle events of a VM, or of all VMs when vm is empty, as json lines
+// until interrupted. The event loop must be running, see RunEventLoop.
+func VirtualMachineWatchEvents(vm string) {
+	var d *libvirt.Domain
+	if vm != "" {
+		var err error
+		d, err = libvirtInstance.LookupDomainByName(vm)
+		herr(err)
+		if err != nil {
+			hexit(1)
+		}
+		defer FreeDomain(d)()
+	}
+
+	callbackId, err := libvirtInstance.DomainEventLifecycleRegister(d, func(c *libvirt.Connect, domain *libvirt.Domain, event *libvirt.DomainEventLifecycle) {
+		VmEvent := VirtualMachineEvent{Time: time.Now()}
+		VmEvent.Domain, _ = domain.GetName()
+		// the bindings already know how to name every event and detail, reuse that.
+		fmt.Sscanf(event.String(), "Domain event=%q detail=%q", &VmEvent.Event, &VmEvent.Detail)
+
+		line, err := json.Marshal(VmEvent)
+		herr(err)
+		fmt.Println(string(line))
+	})
+	herr(err)
+	if err != nil {
+		hexit(1)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+
+	err = libvirtInstance.DomainEventDeregister(callbackId)
+	herr(err)
+}
+
+var shutdownModes = map[string]libvirt.DomainShutdownFlags{
+	"acpi":     libvirt.DOMAIN_SHUTDOWN_ACPI_POWER_BTN,
+	"agent":    libvirt.DOMAIN_SHUTDOWN_GUEST_AGENT,
+	"initctl":  libvirt.DOMAIN_SHUTDOWN_INITCTL,
+	"signal":   libvirt.DOMAIN_SHUTDOWN_SIGNAL,
+	"paravirt": libvirt.DOMAIN_SHUTDOWN_PARAVIRT,
+}
+
+// ParseShutdownMode converts a comma separated list of shutdown modes into libvirt flags.
+// When several modes are given the hypervisor picks any of them it supports.
+func ParseShutdownMode(mode string) (libvirt.DomainShutdownFlags, error) {
+	flags := libvirt.DOMAIN_SHUTDOWN_DEFAULT
+	if mode == "" {
+		return flags, nil
+	}
+
+	for _, m := range strings.Split(mode, ",") {
+		flag, ok := shutdownModes[strings.TrimSpace(m)]
+		if !ok {
+			return flags, fmt.Errorf("unknown shutdown mode %v, use acpi, agent, initctl, signal or paravirt", m)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+var rebootModes = map[string]libvirt.DomainRebootFlagValues{
+	"acpi":     libvirt.DOMAIN_REBOOT_ACPI_POWER_BTN,
+	"agent":    libvirt.DOMAIN_REBOOT_GUEST_AGENT,
+	"initctl":  libvirt.DOMAIN_REBOOT_INITCTL,
+	"signal":   libvirt.DOMAIN_REBOOT_SIGNAL,
+	"paravirt": libvirt.DOMAIN_REBOOT_PARAVIRT,
+}
+
+// ParseRebootMode converts a comma separated list of reboot modes into libvirt flags, same as ParseShutdownMode.
+func ParseRebootMode(mode string) (libvirt.DomainRebootFlagValues, error) {
+	flags := libvirt.DOMAIN_REBOOT_DEFAULT
+	if mode == "" {
+		return flags, nil
+	}
+
+	for _, m := range strings.Split(mode, ",") {
+		flag, ok := rebootModes[strings.TrimSpace(m)]
+		if !ok {
+			return flags, fmt.Errorf("unknown reboot mode %v, use acpi, agent, initctl, signal or paravirt", m)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+var suspendTargets = map[string]libvirt.NodeSuspendTarget{
+	"mem":    libvirt.NODE_SUSPEND_TARGET_MEM,
+	"disk":   libvirt.NODE_SUSPEND_TARGET_DISK,
+	"hybrid": libvirt.NODE_SUSPEND_TARGET_HYBRID,
+}
+
+// VirtualMachinePMSuspend asks the guest to suspend itself to ram, disk or both, and optionally wake up after a duration.
+// A guest suspended to disk powers off, so the vm ends up shutoff rather than pmsuspended.
+// With a non zero timeout it waits for the guest to get there.
+func VirtualMachinePMSuspend(ctx context.Context, vm string, target string, wakeupAfter time.Duration, timeout time.Duration) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	SuspendTarget, ok := suspendTargets[target]
+	if !ok {
+		herr(fmt.Errorf("unknown suspend target %v, use mem, disk or hybrid", target))
+		return
+	}
+
+	err = d.PMSuspendForDuration(SuspendTarget, uint64(wakeupAfter.Seconds()), 0)
+	herr(AgentError(err))
+	if err != nil {
+		return
+	}
+
+	if timeout == 0 {
+		hret(GetVirtualMachineStateInfo(vm))
+	}
+
+	state := VirtStateHybernating
+	if SuspendTarget == libvirt.NODE_SUSPEND_TARGET_DISK {
+		state = VirtStateShutoff
+	}
+	VmState, _ := WaitForVirtualMachineState(ctx, vm, state)
+	hret(VmState)
+}
+
+// VirtualMachinePMWakeup wakes up a guest suspended to ram by VirtualMachinePMSuspend.
+// With a non zero timeout it waits for the guest to run again.
+func VirtualMachinePMWakeup(ctx context.Context, vm string, timeout time.Duration) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = d.PMWakeup(0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	if timeout == 0 {
+		hret(GetVirtualMachineStateInfo(vm))
+	}
+
+	VmState, _ := WaitForVirtualMachineState(ctx, vm, VirtStateRunning)
+	hret(VmState)
+}
+
+// QemuPidDirs returns where the qemu driver keeps pid files of running vms, for the system and the session daemon.
+// The session one is only known with XDG_RUNTIME_DIR set.
+func QemuPidDirs() []string {
+	dirs := []string{"/run/libvirt/qemu"}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dirs = append(dirs, filepath.Join(runtimeDir, "libvirt/qemu/run"))
+	}
+	return dirs
+}
+
+// GetVirtualMachineUptime returns how long a running VM has been up and where that comes from, as libvirt does not
+// tell the start time. On the vm host it is the age of the qemu pid file, written when the vm started ("pidfile").
+// Elsewhere only the cpu time spread over the vcpus is known, a lower bound that matches for busy vms ("cputime").
+func GetVirtualMachineUptime(vm string, dominfo *libvirt.DomainInfo) (int64, string) {
+	// pid files on this host only say something about vms of a local connection.
+	for _, dir := range QemuPidDirs() {
+		if uri, err := url.Parse(*connectUri); err != nil || uri.Host != "" {
+			break
+		}
+		if stat, err := os.Stat(filepath.Join(dir, vm+".pid")); err == nil {
+			return int64(time.Since(stat.ModTime()).Seconds()), "pidfile"
+		}
+	}
+
+	if dominfo == nil || dominfo.NrVirtCpu == 0 {
+		return 0, ""
+	}
+	return int64(time.Duration(dominfo.CpuTime / uint64(dominfo.NrVirtCpu)).Seconds()), "cputime"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"libvirt.org/go/libvirt"
+)
+
+func TestParseShutdownMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    libvirt.DomainShutdownFlags
+		wantErr bool
+	}{
+		{mode: "", want: libvirt.DOMAIN_SHUTDOWN_DEFAULT},
+		{mode: "acpi", want: libvirt.DOMAIN_SHUTDOWN_ACPI_POWER_BTN},
+		{mode: "agent", want: libvirt.DOMAIN_SHUTDOWN_GUEST_AGENT},
+		{mode: "agent, acpi", want: libvirt.DOMAIN_SHUTDOWN_GUEST_AGENT | libvirt.DOMAIN_SHUTDOWN_ACPI_POWER_BTN},
+		{mode: "initctl,signal,paravirt", want: libvirt.DOMAIN_SHUTDOWN_INITCTL | libvirt.DOMAIN_SHUTDOWN_SIGNAL | libvirt.DOMAIN_SHUTDOWN_PARAVIRT},
+		{mode: "ACPI", wantErr: true},
+		{mode: "acpi,", wantErr: true},
+		{mode: "poweroff", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseShutdownMode(tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseShutdownMode(%q) error = %v, want error %v", tt.mode, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseShutdownMode(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestParseRebootMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    libvirt.DomainRebootFlagValues
+		wantErr bool
+	}{
+		{mode: "", want: libvirt.DOMAIN_REBOOT_DEFAULT},
+		{mode: "acpi", want: libvirt.DOMAIN_REBOOT_ACPI_POWER_BTN},
+		{mode: "agent", want: libvirt.DOMAIN_REBOOT_GUEST_AGENT},
+		{mode: "agent, acpi", want: libvirt.DOMAIN_REBOOT_GUEST_AGENT | libvirt.DOMAIN_REBOOT_ACPI_POWER_BTN},
+		{mode: "initctl,signal,paravirt", want: libvirt.DOMAIN_REBOOT_INITCTL | libvirt.DOMAIN_REBOOT_SIGNAL | libvirt.DOMAIN_REBOOT_PARAVIRT},
+		{mode: "ACPI", wantErr: true},
+		{mode: "acpi,", wantErr: true},
+		{mode: "reset", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRebootMode(tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRebootMode(%q) error = %v, want error %v", tt.mode, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseRebootMode(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
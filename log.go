@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Diagnostics go to stderr as logfmt lines, so they never get mixed into results on stdout.
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+// logLevel is the least important level logged, set from --log-level.
+var logLevel = LevelWarn
+
+// ParseLogLevel converts a --log-level name into a level.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if levelName == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	return LevelWarn, fmt.Errorf("unknown log level %v, use debug, info, warn or error", name)
+}
+
+// Log writes a message with key value pairs of attributes, e.g. Log(LevelInfo, "connected", "uri", uri).
+func Log(level LogLevel, msg string, attrs ...any) {
+	if level < logLevel {
+		return
+	}
+
+	line := fmt.Sprintf("time=%v level=%v msg=%q", time.Now().Format(time.RFC3339Nano), logLevelNames[level], msg)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		value := fmt.Sprint(attrs[i+1])
+		if strings.ContainsAny(value, " \"=") || value == "" {
+			value = fmt.Sprintf("%q", value)
+		}
+		line += fmt.Sprintf(" %v=%v", attrs[i], value)
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// operation is the command being run, logged with its duration once it is done.
+var operation struct {
+	Name  string
+	Vm    string
+	Start time.Time
+}
+
+// LogOperation logs the end of the operation, if one was started.
+func LogOperation(code int) {
+	if operation.Name == "" {
+		return
+	}
+
+	level := LevelInfo
+	if code != 0 {
+		level = LevelError
+	}
+	Log(level, "operation done", "op", operation.Name, "vm", operation.Vm, "duration", time.Since(operation.Start), "exit", code)
+}
+
+// exitHooks run when the process ends through hexit, which skips deferred calls.
+var exitHooks []func()
+
+// OnExit registers a hook to run on hexit. Hooks run newest first, like deferred calls.
+func OnExit(hook func()) {
+	exitHooks = append(exitHooks, hook)
+}
+
+// hexit ends the process, every result printing helper exits through it so the operation is logged.
+func hexit(code int) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	LogOperation(code)
+	os.Exit(code)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineMetadata struct {
+	Title       string
+	Description string
+}
+
+// VirtualMachineSetMetadata sets a title and/or a description of a VM. Nil leaves the value untouched, empty string clears it.
+func VirtualMachineSetMetadata(vm string, title *string, description *string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	if title != nil {
+		err = d.SetMetadata(libvirt.DOMAIN_METADATA_TITLE, *title, "", "", ModificationImpact())
+		herr(err)
+	}
+	if description != nil {
+		err = d.SetMetadata(libvirt.DOMAIN_METADATA_DESCRIPTION, *description, "", "", ModificationImpact())
+		herr(err)
+	}
+
+	hret(GetVirtualMachineMetadata(d))
+}
+
+// VirtualMachineGetMetadata returns a title and a description of a VM.
+func VirtualMachineGetMetadata(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	hret(GetVirtualMachineMetadata(d))
+}
+
+func GetVirtualMachineMetadata(d *libvirt.Domain) VirtualMachineMetadata {
+	var Metadata VirtualMachineMetadata
+	var err error
+
+	flags := QueryImpact()
+
+	Metadata.Title, err = d.GetMetadata(libvirt.DOMAIN_METADATA_TITLE, "", flags)
+	if !IsLibvirtError(err, libvirt.ERR_NO_DOMAIN_METADATA) {
+		herr(err)
+	}
+
+	Metadata.Description, err = d.GetMetadata(libvirt.DOMAIN_METADATA_DESCRIPTION, "", flags)
+	if !IsLibvirtError(err, libvirt.ERR_NO_DOMAIN_METADATA) {
+		herr(err)
+	}
+
+	return Metadata
+}
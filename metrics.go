@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+// PrometheusMetric is a single metric family in the Prometheus text exposition format.
+type PrometheusMetric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []string
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Add appends a sample to the metric. Labels are passed as name, value pairs.
+func (m *PrometheusMetric) Add(value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], prometheusLabelEscaper.Replace(labels[i+1])))
+	}
+
+	m.Samples = append(m.Samples, fmt.Sprintf("%s{%s} %s", m.Name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64)))
+}
+
+func (m *PrometheusMetric) String() string {
+	var OutputString strings.Builder
+
+	fmt.Fprintf(&OutputString, "# HELP %s %s\n", m.Name, m.Help)
+	fmt.Fprintf(&OutputString, "# TYPE %s %s\n", m.Name, m.Type)
+	for _, sample := range m.Samples {
+		OutputString.WriteString(sample)
+		OutputString.WriteString("\n")
+	}
+
+	return OutputString.String()
+}
+
+// VirtualMachinesMetrics prints metrics of all domains on host in the Prometheus text format,
+// suitable for the node_exporter textfile collector.
+func VirtualMachinesMetrics() {
+	cpuTime := &PrometheusMetric{Name: "libvirt_domain_cpu_time_seconds", Help: "CPU time used by the domain.", Type: "counter"}
+	memory := &PrometheusMetric{Name: "libvirt_domain_memory_bytes", Help: "Memory currently allocated to the domain.", Type: "gauge"}
+	state := &PrometheusMetric{Name: "libvirt_domain_state", Help: "Current state of the domain, the state label is set to 1.", Type: "gauge"}
+
+	blockReadBytes := &PrometheusMetric{Name: "libvirt_domain_block_read_bytes_total", Help: "Bytes read from a disk.", Type: "counter"}
+	blockReadRequests := &PrometheusMetric{Name: "libvirt_domain_block_read_requests_total", Help: "Read requests issued to a disk.", Type: "counter"}
+	blockWriteBytes := &PrometheusMetric{Name: "libvirt_domain_block_write_bytes_total", Help: "Bytes written to a disk.", Type: "counter"}
+	blockWriteRequests := &PrometheusMetric{Name: "libvirt_domain_block_write_requests_total", Help: "Write requests issued to a disk.", Type: "counter"}
+	blockErrors := &PrometheusMetric{Name: "libvirt_domain_block_errors_total", Help: "Errors on a disk.", Type: "counter"}
+
+	netRxBytes := &PrometheusMetric{Name: "libvirt_domain_interface_receive_bytes_total", Help: "Bytes received on an interface.", Type: "counter"}
+	netRxPackets := &PrometheusMetric{Name: "libvirt_domain_interface_receive_packets_total", Help: "Packets received on an interface.", Type: "counter"}
+	netRxErrs := &PrometheusMetric{Name: "libvirt_domain_interface_receive_errors_total", Help: "Receive errors on an interface.", Type: "counter"}
+	netRxDrop := &PrometheusMetric{Name: "libvirt_domain_interface_receive_drops_total", Help: "Received packets dropped on an interface.", Type: "counter"}
+	netTxBytes := &PrometheusMetric{Name: "libvirt_domain_interface_transmit_bytes_total", Help: "Bytes transmitted on an interface.", Type: "counter"}
+	netTxPackets := &PrometheusMetric{Name: "libvirt_domain_interface_transmit_packets_total", Help: "Packets transmitted on an interface.", Type: "counter"}
+	netTxErrs := &PrometheusMetric{Name: "libvirt_domain_interface_transmit_errors_total", Help: "Transmit errors on an interface.", Type: "counter"}
+	netTxDrop := &PrometheusMetric{Name: "libvirt_domain_interface_transmit_drops_total", Help: "Transmitted packets dropped on an interface.", Type: "counter"}
+
+	// anything printed besides metrics breaks the scrape, so failures are logged and the samples skipped.
+	AllDomains, err := libvirtInstance.ListAllDomains(0)
+	if err != nil {
+		Log(LevelError, "listing domains failed", "error", err)
+	}
+
+	for i := range AllDomains {
+		func(domain *libvirt.Domain) {
+			defer domain.Free()
+
+			DomainName, err := domain.GetName()
+			if err != nil {
+				Log(LevelWarn, "skipping domain metrics", "error", err)
+				return
+			}
+
+			dominfo, err := domain.GetInfo()
+			if err != nil {
+				Log(LevelWarn, "skipping domain metrics", "domain", DomainName, "error", err)
+				return
+			}
+			// cpu time is reported in nanoseconds, memory in kilobytes.
+			cpuTime.Add(float64(dominfo.CpuTime)/1e9, "domain", DomainName)
+			memory.Add(float64(dominfo.Memory*1024), "domain", DomainName)
+			state.Add(1, "domain", DomainName, "state", string(VirtualMachineStatusOf(dominfo.State)))
+
+			// block and interface counters only exist for running domains.
+			if active, err := domain.IsActive(); err != nil || !active {
+				return
+			}
+
+			DomXML, err := GetDomainXML(domain, 0)
+			if err != nil {
+				Log(LevelWarn, "skipping disk metrics", "domain", DomainName, "error", err)
+			}
+			for _, disk := range DomXML.Devices.Disks {
+				stats, err := domain.BlockStats(disk.Target.Dev)
+				if err != nil {
+					continue
+				}
+				blockReadBytes.Add(float64(stats.RdBytes), "domain", DomainName, "target_device", disk.Target.Dev)
+				blockReadRequests.Add(float64(stats.RdReq), "domain", DomainName, "target_device", disk.Target.Dev)
+				blockWriteBytes.Add(float64(stats.WrBytes), "domain", DomainName, "target_device", disk.Target.Dev)
+				blockWriteRequests.Add(float64(stats.WrReq), "domain", DomainName, "target_device", disk.Target.Dev)
+				blockErrors.Add(float64(stats.Errs), "domain", DomainName, "target_device", disk.Target.Dev)
+			}
+
+			NetStats, err := GetVirtualMachineNetStats(domain)
+			if err != nil {
+				Log(LevelWarn, "skipping interface metrics", "domain", DomainName, "error", err)
+			}
+			for dev, stats := range NetStats {
+				netRxBytes.Add(float64(stats.RxBytes), "domain", DomainName, "target_device", dev)
+				netRxPackets.Add(float64(stats.RxPackets), "domain", DomainName, "target_device", dev)
+				netRxErrs.Add(float64(stats.RxErrs), "domain", DomainName, "target_device", dev)
+				netRxDrop.Add(float64(stats.RxDrop), "domain", DomainName, "target_device", dev)
+				netTxBytes.Add(float64(stats.TxBytes), "domain", DomainName, "target_device", dev)
+				netTxPackets.Add(float64(stats.TxPackets), "domain", DomainName, "target_device", dev)
+				netTxErrs.Add(float64(stats.TxErrs), "domain", DomainName, "target_device", dev)
+				netTxDrop.Add(float64(stats.TxDrop), "domain", DomainName, "target_device", dev)
+			}
+		}(&AllDomains[i])
+	}
+
+	for _, metric := range []*PrometheusMetric{
+		cpuTime, memory, state,
+		blockReadBytes, blockReadRequests, blockWriteBytes, blockWriteRequests, blockErrors,
+		netRxBytes, netRxPackets, netRxErrs, netRxDrop, netTxBytes, netTxPackets, netTxErrs, netTxDrop,
+	} {
+		fmt.Print(metric.String())
+	}
+}
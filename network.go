@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type NetworkInfo struct {
+	Name       string
+	Uuid       string
+	Active     bool
+	Autostart  bool
+	Persistent bool
+	Bridge     string
+}
+
+// NetworkList returns all virtual networks on host.
+func NetworkList() {
+	AllNetworks, err := libvirtInstance.ListAllNetworks(0)
+	herr(err)
+
+	ret := []NetworkInfo{}
+	for _, network := range AllNetworks {
+		ret = append(ret, GetNetworkInfo(&network))
+		network.Free()
+	}
+
+	var rows [][]string
+	for _, info := range ret {
+		rows = append(rows, []string{info.Name, info.Uuid, fmt.Sprint(info.Active), fmt.Sprint(info.Autostart),
+			fmt.Sprint(info.Persistent), info.Bridge})
+	}
+	htable(ret, []string{"NAME", "UUID", "ACTIVE", "AUTOSTART", "PERSISTENT", "BRIDGE"}, rows)
+}
+
+func GetNetworkInfo(network *libvirt.Network) NetworkInfo {
+	var NetInfo NetworkInfo
+	var err error
+
+	NetInfo.Name, err = network.GetName()
+	herr(err)
+
+	NetInfo.Uuid, err = network.GetUUIDString()
+	herr(err)
+
+	NetInfo.Active, err = network.IsActive()
+	herr(err)
+
+	NetInfo.Autostart, err = network.GetAutostart()
+	herr(err)
+
+	NetInfo.Persistent, err = network.IsPersistent()
+	herr(err)
+
+	// networks that do not create a bridge (e.g. macvtap, hostdev) have no bridge name.
+	NetInfo.Bridge, _ = network.GetBridgeName()
+
+	return NetInfo
+}
+
+type NetworkLease struct {
+	Mac        string
+	IpAddress  string
+	Prefix     uint
+	Hostname   string
+	ExpiryTime time.Time
+	ClientId   string
+}
+
+// NetworkLeases returns DHCP leases handed out by a virtual network. Unlike --ips this does not need a guest agent.
+func NetworkLeases(networkName string) {
+	network, err := libvirtInstance.LookupNetworkByName(networkName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer network.Free()
+
+	leases, err := network.GetDHCPLeases()
+	herr(err)
+
+	ret := []NetworkLease{}
+	for _, lease := range leases {
+		ret = append(ret, NetworkLease{
+			Mac:        lease.Mac,
+			IpAddress:  lease.IPaddr,
+			Prefix:     lease.Prefix,
+			Hostname:   lease.Hostname,
+			ExpiryTime: lease.ExpiryTime,
+			ClientId:   lease.Clientid,
+		})
+	}
+
+	hret(ret)
+}
+
+// NetworkCreate defines a new virtual network from an xml file or url, optionally starting it and marking it for autostart.
+func NetworkCreate(xmlTemplate string, start bool, autostart bool) {
+	NetXML, err := ReadXMLTemplate(xmlTemplate)
+	if err == nil {
+		err = CheckXML(xmlTemplate, NetXML)
+	}
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	network, err := libvirtInstance.NetworkDefineXMLFlags(string(NetXML), libvirt.NETWORK_DEFINE_VALIDATE)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer network.Free()
+
+	if start {
+		err = network.Create()
+		herr(err)
+	}
+
+	if autostart {
+		err = network.SetAutostart(true)
+		herr(err)
+	}
+
+	hret(GetNetworkInfo(network))
+}
+
+// NetworkDestroy stops a virtual network. Networks used by running domains are only stopped when forced.
+func NetworkDestroy(networkName string, force bool) {
+	network, err := libvirtInstance.LookupNetworkByName(networkName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer network.Free()
+
+	bridge, _ := network.GetBridgeName()
+	if users := FindNetworkUsers(networkName, bridge); len(users) > 0 && !force {
+		herr(fmt.Errorf("network %v is used by %v, pass --force to destroy it anyway", networkName, strings.Join(users, ", ")))
+		return
+	}
+
+	err = network.Destroy()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("network %v was destroyed", networkName))
+}
+
+// NetworkUndefine removes the definition of a virtual network. A running network keeps running until destroyed.
+func NetworkUndefine(networkName string) {
+	network, err := libvirtInstance.LookupNetworkByName(networkName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer network.Free()
+
+	err = network.Undefine()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("network %v was undefined", networkName))
+}
+
+// FindNetworkUsers returns names of running domains with an interface in a network, either through
+// the network itself or directly through its bridge.
+func FindNetworkUsers(networkName string, bridge string) []string {
+	var users []string
+
+	AllDomains, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_ACTIVE)
+	herr(err)
+
+	for _, domain := range AllDomains {
+		DomainName, err := domain.GetName()
+		herr(err)
+
+		DomXML, err := GetDomainXML(&domain, 0)
+		herr(err)
+
+		for _, iface := range DomXML.Devices.Interfaces {
+			if iface.Source.Network == networkName || (bridge != "" && iface.Source.Bridge == bridge) {
+				users = append(users, DomainName)
+				break
+			}
+		}
+		domain.Free()
+	}
+
+	return users
+}
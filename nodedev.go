@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+type NodeDeviceInfo struct {
+	Name   string
+	Parent string
+	Caps   []string
+}
+
+// nodeDeviceCaps are capability names, as virsh and the node device xml call them.
+var nodeDeviceCaps = map[string]libvirt.ConnectListAllNodeDeviceFlags{
+	"system":       libvirt.CONNECT_LIST_NODE_DEVICES_CAP_SYSTEM,
+	"pci":          libvirt.CONNECT_LIST_NODE_DEVICES_CAP_PCI_DEV,
+	"usb_device":   libvirt.CONNECT_LIST_NODE_DEVICES_CAP_USB_DEV,
+	"usb":          libvirt.CONNECT_LIST_NODE_DEVICES_CAP_USB_INTERFACE,
+	"net":          libvirt.CONNECT_LIST_NODE_DEVICES_CAP_NET,
+	"scsi_host":    libvirt.CONNECT_LIST_NODE_DEVICES_CAP_SCSI_HOST,
+	"scsi_target":  libvirt.CONNECT_LIST_NODE_DEVICES_CAP_SCSI_TARGET,
+	"scsi":         libvirt.CONNECT_LIST_NODE_DEVICES_CAP_SCSI,
+	"storage":      libvirt.CONNECT_LIST_NODE_DEVICES_CAP_STORAGE,
+	"fc_host":      libvirt.CONNECT_LIST_NODE_DEVICES_CAP_FC_HOST,
+	"vports":       libvirt.CONNECT_LIST_NODE_DEVICES_CAP_VPORTS,
+	"scsi_generic": libvirt.CONNECT_LIST_NODE_DEVICES_CAP_SCSI_GENERIC,
+	"drm":          libvirt.CONNECT_LIST_NODE_DEVICES_CAP_DRM,
+	"mdev":         libvirt.CONNECT_LIST_NODE_DEVICES_CAP_MDEV,
+	"mdev_types":   libvirt.CONNECT_LIST_NODE_DEVICES_CAP_MDEV_TYPES,
+	"ccw":          libvirt.CONNECT_LIST_NODE_DEVICES_CAP_CCW_DEV,
+	"vdpa":         libvirt.CONNECT_LIST_NODE_DEVICES_CAP_VDPA,
+}
+
+// NodeDeviceList returns host devices known to libvirt, optionally only those with a given capability.
+func NodeDeviceList(capability string) {
+	var flags libvirt.ConnectListAllNodeDeviceFlags
+	if capability != "" {
+		flag, ok := nodeDeviceCaps[capability]
+		if !ok {
+			var known []string
+			for name := range nodeDeviceCaps {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			herr(fmt.Errorf("unknown capability %v, use one of %v", capability, strings.Join(known, ", ")))
+			return
+		}
+		flags = flag
+	}
+
+	AllDevices, err := libvirtInstance.ListAllNodeDevices(flags)
+	herr(err)
+
+	ret := []NodeDeviceInfo{}
+	for _, device := range AllDevices {
+		ret = append(ret, GetNodeDeviceInfo(&device))
+		device.Free()
+	}
+
+	var rows [][]string
+	for _, info := range ret {
+		rows = append(rows, []string{info.Name, info.Parent, strings.Join(info.Caps, ",")})
+	}
+	htable(ret, []string{"NAME", "PARENT", "CAPABILITIES"}, rows)
+}
+
+func GetNodeDeviceInfo(device *libvirt.NodeDevice) NodeDeviceInfo {
+	var DevInfo NodeDeviceInfo
+	var err error
+
+	DevInfo.Name, err = device.GetName()
+	herr(err)
+
+	// the root device has no parent, which is not an error worth reporting.
+	DevInfo.Parent, _ = device.GetParent()
+
+	DevInfo.Caps, err = device.ListCaps()
+	herr(err)
+
+	return DevInfo
+}
+
+// NodeDeviceXML prints the xml description of a host device as it is.
+func NodeDeviceXML(name string) {
+	device, err := libvirtInstance.LookupDeviceByName(name)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer device.Free()
+
+	desc, err := device.GetXMLDesc(0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	fmt.Print(desc)
+	hexit(0)
+}
+
+type PciAddress struct {
+	Domain   uint
+	Bus      uint
+	Slot     uint
+	Function uint
+}
+
+// PciAddressXML is a pci address as libvirt writes it, every part in hex.
+type PciAddressXML struct {
+	Type     string `xml:"type,attr,omitempty"`
+	Domain   string `xml:"domain,attr"`
+	Bus      string `xml:"bus,attr"`
+	Slot     string `xml:"slot,attr"`
+	Function string `xml:"function,attr"`
+}
+
+type HostdevXML struct {
+	XMLName xml.Name `xml:"hostdev"`
+	Mode    string   `xml:"mode,attr"`
+	Type    string   `xml:"type,attr"`
+	Managed string   `xml:"managed,attr"`
+	Source  struct {
+		Address PciAddressXML `xml:"address"`
+	} `xml:"source"`
+}
+
+// ParsePciAddress parses a pci address in the domain:bus:slot.function form, e.g. 0000:01:00.0.
+// The domain part may be omitted, same as in lspci.
+func ParsePciAddress(address string) (PciAddress, error) {
+	bad := fmt.Errorf("%v is not a pci address, use domain:bus:slot.function, e.g. 0000:01:00.0", address)
+
+	parts := strings.Split(address, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+	if len(parts) != 3 {
+		return PciAddress{}, bad
+	}
+	slot, function, ok := strings.Cut(parts[2], ".")
+	if !ok {
+		return PciAddress{}, bad
+	}
+
+	// hex without a 0x prefix, each part limited to the bits it has in a pci address.
+	var values [4]uint
+	for i, part := range []struct {
+		value string
+		bits  int
+	}{{parts[0], 16}, {parts[1], 8}, {slot, 5}, {function, 3}} {
+		value, err := strconv.ParseUint(part.value, 16, part.bits)
+		if err != nil {
+			return PciAddress{}, bad
+		}
+		values[i] = uint(value)
+	}
+
+	return PciAddress{Domain: values[0], Bus: values[1], Slot: values[2], Function: values[3]}, nil
+}
+
+func (addr PciAddress) String() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", addr.Domain, addr.Bus, addr.Slot, addr.Function)
+}
+
+// NodeDeviceName returns the name libvirt gives to a host pci device, e.g. pci_0000_01_00_0.
+func (addr PciAddress) NodeDeviceName() string {
+	return fmt.Sprintf("pci_%04x_%02x_%02x_%x", addr.Domain, addr.Bus, addr.Slot, addr.Function)
+}
+
+func (addr PciAddress) XML() PciAddressXML {
+	return PciAddressXML{
+		Domain:   fmt.Sprintf("0x%04x", addr.Domain),
+		Bus:      fmt.Sprintf("0x%02x", addr.Bus),
+		Slot:     fmt.Sprintf("0x%02x", addr.Slot),
+		Function: fmt.Sprintf("0x%x", addr.Function),
+	}
+}
+
+// Address parses a pci address written by libvirt.
+func (x PciAddressXML) Address() (PciAddress, error) {
+	var parts [4]uint
+	for i, part := range []string{x.Domain, x.Bus, x.Slot, x.Function} {
+		value, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return PciAddress{}, fmt.Errorf("bad pci address part %v: %v", part, err)
+		}
+		parts[i] = uint(value)
+	}
+
+	return PciAddress{Domain: parts[0], Bus: parts[1], Slot: parts[2], Function: parts[3]}, nil
+}
+
+// PciHostdevXML returns a hostdev fragment passing a host pci device through. The device is detached from its host
+// driver by us, so libvirt is told not to manage it.
+func PciHostdevXML(addr PciAddress) (string, error) {
+	Hostdev := HostdevXML{Mode: "subsystem", Type: "pci", Managed: "no"}
+	Hostdev.Source.Address = addr.XML()
+
+	desc, err := xml.Marshal(Hostdev)
+	return string(desc), err
+}
+
+// VirtualMachineAttachHostdev passes a host pci device, e.g. a gpu or a nic, through to a VM.
+// The device is taken from its host driver first, and given back when the attach fails.
+func VirtualMachineAttachHostdev(vm string, pciAddress string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	addr, err := ParsePciAddress(pciAddress)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	device, err := libvirtInstance.LookupDeviceByName(addr.NodeDeviceName())
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer device.Free()
+
+	desc, err := PciHostdevXML(addr)
+	herr(err)
+
+	err = device.Detach()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.AttachDeviceFlags(desc, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		herr(device.ReAttach())
+		return
+	}
+
+	hok(fmt.Sprintf("%v was attached to %v", pciAddress, vm))
+}
+
+// VirtualMachineDetachHostdev takes a host pci device away from a VM and gives it back to its host driver.
+func VirtualMachineDetachHostdev(vm string, pciAddress string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	addr, err := ParsePciAddress(pciAddress)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	desc, err := PciHostdevXML(addr)
+	herr(err)
+
+	err = d.DetachDeviceFlags(desc, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	device, err := libvirtInstance.LookupDeviceByName(addr.NodeDeviceName())
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer device.Free()
+
+	err = device.ReAttach()
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("%v was detached from %v and given back to the host", pciAddress, vm))
+}
+
+// NodeDevicePciXML is a partial model of the node device xml of a pci device.
+type NodeDevicePciXML struct {
+	Name         string `xml:"name"`
+	Capabilities []struct {
+		Type         string `xml:"type,attr"`
+		Capabilities []struct {
+			Type      string          `xml:"type,attr"`
+			Addresses []PciAddressXML `xml:"address"`
+		} `xml:"capability"`
+	} `xml:"capability"`
+}
+
+// VirtualFunctions returns addresses of the sr-iov virtual functions of a physical function.
+func (dev *NodeDevicePciXML) VirtualFunctions() []PciAddressXML {
+	for _, pci := range dev.Capabilities {
+		for _, capability := range pci.Capabilities {
+			if pci.Type == "pci" && capability.Type == "virt_functions" {
+				return capability.Addresses
+			}
+		}
+	}
+	return nil
+}
+
+type VirtualFunction struct {
+	Address    string
+	NodeDevice string
+	// AssignedTo is the running vm using the virtual function, if any.
+	AssignedTo string
+
+	address PciAddress
+}
+
+// GetVirtualFunctions returns virtual functions of a physical function, and which running vms use them.
+func GetVirtualFunctions(pf PciAddress) ([]VirtualFunction, error) {
+	VirtualFunctions := []VirtualFunction{}
+
+	device, err := libvirtInstance.LookupDeviceByName(pf.NodeDeviceName())
+	if err != nil {
+		return VirtualFunctions, err
+	}
+	defer device.Free()
+
+	desc, err := device.GetXMLDesc(0)
+	if err != nil {
+		return VirtualFunctions, err
+	}
+
+	var DevXML NodeDevicePciXML
+	if err := xml.Unmarshal([]byte(desc), &DevXML); err != nil {
+		return VirtualFunctions, err
+	}
+
+	assigned, err := AssignedPciDevices()
+	if err != nil {
+		return VirtualFunctions, err
+	}
+
+	for _, vfXML := range DevXML.VirtualFunctions() {
+		vf, err := vfXML.Address()
+		if err != nil {
+			return VirtualFunctions, err
+		}
+		VirtualFunctions = append(VirtualFunctions, VirtualFunction{Address: vf.String(), NodeDevice: vf.NodeDeviceName(), AssignedTo: assigned[vf], address: vf})
+	}
+
+	return VirtualFunctions, nil
+}
+
+// AssignedPciDevices returns host pci devices passed through to running vms, either as a hostdev or as an interface.
+func AssignedPciDevices() (map[PciAddress]string, error) {
+	assigned := map[PciAddress]string{}
+
+	AllDomains, err := libvirtInstance.ListAllDomains(libvirt.CONNECT_LIST_DOMAINS_ACTIVE)
+	if err != nil {
+		return assigned, err
+	}
+
+	for _, domain := range AllDomains {
+		DomXML, err := GetDomainXML(&domain, 0)
+		domain.Free()
+		if err != nil {
+			return assigned, err
+		}
+
+		var addresses []*PciAddressXML
+		for _, hostdev := range DomXML.Devices.Hostdevs {
+			if hostdev.Type == "pci" {
+				addresses = append(addresses, hostdev.Source.Address)
+			}
+		}
+		for _, iface := range DomXML.Devices.Interfaces {
+			if iface.Type == "hostdev" {
+				addresses = append(addresses, iface.Source.Address)
+			}
+		}
+
+		for _, addrXML := range addresses {
+			if addrXML == nil {
+				continue
+			}
+			if addr, err := addrXML.Address(); err == nil {
+				assigned[addr] = DomXML.Name
+			}
+		}
+	}
+
+	return assigned, nil
+}
+
+// VirtualFunctionList returns sr-iov virtual functions of a host nic, and which running vms use them.
+func VirtualFunctionList(pfAddress string) {
+	pf, err := ParsePciAddress(pfAddress)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret, err := GetVirtualFunctions(pf)
+	herr(err)
+
+	var rows [][]string
+	for _, vf := range ret {
+		rows = append(rows, []string{vf.Address, vf.NodeDevice, vf.AssignedTo})
+	}
+	htable(ret, []string{"ADDRESS", "NODE DEVICE", "ASSIGNED TO"}, rows)
+}
+
+type VfInterfaceXML struct {
+	XMLName xml.Name `xml:"interface"`
+	Type    string   `xml:"type,attr"`
+	Managed string   `xml:"managed,attr"`
+	Source  struct {
+		Address PciAddressXML `xml:"address"`
+	} `xml:"source"`
+}
+
+// VirtualMachineAttachVf passes a free sr-iov virtual function of a host nic through to a VM. As an interface
+// the vm sees a nic libvirt can set a mac address on, as a hostdev a plain pci device.
+// libvirt takes the virtual function from its host driver itself.
+func VirtualMachineAttachVf(vm string, pfAddress string, mode string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	pf, err := ParsePciAddress(pfAddress)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualFunctions, err := GetVirtualFunctions(pf)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	var free *PciAddress
+	for _, vf := range VirtualFunctions {
+		if vf.AssignedTo == "" {
+			free = &vf.address
+			break
+		}
+	}
+	if free == nil {
+		herr(fmt.Errorf("%v has no free virtual functions out of %d", pfAddress, len(VirtualFunctions)))
+		return
+	}
+
+	var desc []byte
+	switch mode {
+	case "interface":
+		Interface := VfInterfaceXML{Type: "hostdev", Managed: "yes"}
+		Interface.Source.Address = free.XML()
+		Interface.Source.Address.Type = "pci"
+		desc, err = xml.Marshal(Interface)
+	case "hostdev":
+		Hostdev := HostdevXML{Mode: "subsystem", Type: "pci", Managed: "yes"}
+		Hostdev.Source.Address = free.XML()
+		desc, err = xml.Marshal(Hostdev)
+	default:
+		err = fmt.Errorf("unknown vf mode %v, use interface or hostdev", mode)
+	}
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	err = d.AttachDeviceFlags(string(desc), DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("virtual function %v was attached to %v", free, vm))
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParsePciAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    PciAddress
+		wantErr bool
+	}{
+		{address: "0000:01:00.0", want: PciAddress{Bus: 1}},
+		{address: "01:00.1", want: PciAddress{Bus: 1, Function: 1}},
+		{address: "0001:af:1f.7", want: PciAddress{Domain: 1, Bus: 0xaf, Slot: 0x1f, Function: 7}},
+		{address: "0000:3B:02.0", want: PciAddress{Bus: 0x3b, Slot: 2}},
+		{address: "", wantErr: true},
+		{address: "01:00", wantErr: true},
+		{address: "0000:01:00:0", wantErr: true},
+		{address: "0000:01:00.0x", wantErr: true},
+		{address: "0x0000:0x01:0x00.0x0", wantErr: true},
+		{address: "0000:01:20.0", wantErr: true},
+		{address: "0000:01:00.8", wantErr: true},
+		{address: "0000:100:00.0", wantErr: true},
+		{address: "10000:01:00.0", wantErr: true},
+		{address: "0000:0g:00.0", wantErr: true},
+		{address: "pci_0000_01_00_0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePciAddress(tt.address)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePciAddress(%q) error = %v, want error %v", tt.address, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePciAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
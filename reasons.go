@@ -0,0 +1,85 @@
+package main
+
+import (
+	"libvirt.org/go/libvirt"
+)
+
+var runningReasons = map[libvirt.DomainRunningReason]string{
+	libvirt.DOMAIN_RUNNING_UNKNOWN:            "unknown",
+	libvirt.DOMAIN_RUNNING_BOOTED:             "booted",
+	libvirt.DOMAIN_RUNNING_MIGRATED:           "migrated",
+	libvirt.DOMAIN_RUNNING_RESTORED:           "restored",
+	libvirt.DOMAIN_RUNNING_FROM_SNAPSHOT:      "from snapshot",
+	libvirt.DOMAIN_RUNNING_UNPAUSED:           "unpaused",
+	libvirt.DOMAIN_RUNNING_MIGRATION_CANCELED: "migration canceled",
+	libvirt.DOMAIN_RUNNING_SAVE_CANCELED:      "save canceled",
+	libvirt.DOMAIN_RUNNING_WAKEUP:             "woken up",
+	libvirt.DOMAIN_RUNNING_CRASHED:            "crashed",
+	libvirt.DOMAIN_RUNNING_POSTCOPY:           "post-copy migration",
+	libvirt.DOMAIN_RUNNING_POSTCOPY_FAILED:    "post-copy migration failed",
+}
+
+var pausedReasons = map[libvirt.DomainPausedReason]string{
+	libvirt.DOMAIN_PAUSED_UNKNOWN:         "unknown",
+	libvirt.DOMAIN_PAUSED_USER:            "user",
+	libvirt.DOMAIN_PAUSED_MIGRATION:       "migration",
+	libvirt.DOMAIN_PAUSED_SAVE:            "saving",
+	libvirt.DOMAIN_PAUSED_DUMP:            "dumping",
+	libvirt.DOMAIN_PAUSED_IOERROR:         "i/o error",
+	libvirt.DOMAIN_PAUSED_WATCHDOG:        "watchdog",
+	libvirt.DOMAIN_PAUSED_FROM_SNAPSHOT:   "from snapshot",
+	libvirt.DOMAIN_PAUSED_SHUTTING_DOWN:   "shutting down",
+	libvirt.DOMAIN_PAUSED_SNAPSHOT:        "snapshot",
+	libvirt.DOMAIN_PAUSED_CRASHED:         "crashed",
+	libvirt.DOMAIN_PAUSED_STARTING_UP:     "starting up",
+	libvirt.DOMAIN_PAUSED_POSTCOPY:        "post-copy migration",
+	libvirt.DOMAIN_PAUSED_POSTCOPY_FAILED: "post-copy migration failed",
+	libvirt.DOMAIN_PAUSED_API_ERROR:       "api error",
+}
+
+var shutdownReasons = map[libvirt.DomainShutdownReason]string{
+	libvirt.DOMAIN_SHUTDOWN_UNKNOWN: "unknown",
+	libvirt.DOMAIN_SHUTDOWN_USER:    "user",
+}
+
+var shutoffReasons = map[libvirt.DomainShutoffReason]string{
+	libvirt.DOMAIN_SHUTOFF_UNKNOWN:       "unknown",
+	libvirt.DOMAIN_SHUTOFF_SHUTDOWN:      "shutdown",
+	libvirt.DOMAIN_SHUTOFF_DESTROYED:     "destroyed",
+	libvirt.DOMAIN_SHUTOFF_CRASHED:       "crashed",
+	libvirt.DOMAIN_SHUTOFF_MIGRATED:      "migrated",
+	libvirt.DOMAIN_SHUTOFF_SAVED:         "saved",
+	libvirt.DOMAIN_SHUTOFF_FAILED:        "failed to start",
+	libvirt.DOMAIN_SHUTOFF_FROM_SNAPSHOT: "from snapshot",
+	libvirt.DOMAIN_SHUTOFF_DAEMON:        "daemon",
+}
+
+var crashedReasons = map[libvirt.DomainCrashedReason]string{
+	libvirt.DOMAIN_CRASHED_UNKNOWN:  "unknown",
+	libvirt.DOMAIN_CRASHED_PANICKED: "panicked",
+}
+
+// VirtualMachineStateReason translates a reason reported by virDomainGetState into a readable form.
+// Reason codes mean different things depending on the state they come with.
+func VirtualMachineStateReason(state libvirt.DomainState, reason int) string {
+	var text string
+	var ok bool
+
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		text, ok = runningReasons[libvirt.DomainRunningReason(reason)]
+	case libvirt.DOMAIN_PAUSED:
+		text, ok = pausedReasons[libvirt.DomainPausedReason(reason)]
+	case libvirt.DOMAIN_SHUTDOWN:
+		text, ok = shutdownReasons[libvirt.DomainShutdownReason(reason)]
+	case libvirt.DOMAIN_SHUTOFF:
+		text, ok = shutoffReasons[libvirt.DomainShutoffReason(reason)]
+	case libvirt.DOMAIN_CRASHED:
+		text, ok = crashedReasons[libvirt.DomainCrashedReason(reason)]
+	}
+
+	if !ok {
+		return "unknown"
+	}
+	return text
+}
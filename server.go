@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+// errBadRequest marks mistakes of the caller, e.g. an unknown shutdown mode, so they are not reported as server errors.
+var errBadRequest = errors.New("bad request")
+
+// serverActions are the vm actions the http api runs on POST /vm/{name}/{action}. Each reports the vm state afterwards.
+var serverActions = map[string]func(d *libvirt.Domain, r *http.Request) error{
+	"start": func(d *libvirt.Domain, r *http.Request) error {
+		return d.Create()
+	},
+	"shutdown": func(d *libvirt.Domain, r *http.Request) error {
+		flags, err := ParseShutdownMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		return d.ShutdownFlags(flags)
+	},
+	"shutoff": func(d *libvirt.Domain, r *http.Request) error {
+		return d.Destroy()
+	},
+	"soft-reboot": func(d *libvirt.Domain, r *http.Request) error {
+		flags, err := ParseRebootMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		return d.Reboot(flags)
+	},
+	"hard-reboot": func(d *libvirt.Domain, r *http.Request) error {
+		return d.Reset(0)
+	},
+	"pause": func(d *libvirt.Domain, r *http.Request) error {
+		return d.Suspend()
+	},
+	"resume": func(d *libvirt.Domain, r *http.Request) error {
+		return d.Resume()
+	},
+}
+
+// serveTokenEnv names the environment variable holding the bearer token of the http api, when no --serve-token-file is given.
+const serveTokenEnv = "LIBVIRT_HELPER_TOKEN"
+
+// Serve runs an http api over the one connection, until the process is stopped:
+//
+//	GET  /health                  whether the libvirt connection is alive
+//	GET  /vms                     state of all vms, same as --show-all
+//	GET  /vm/{name}/state         same as --state
+//	GET  /vm/{name}/ips           same as --ips, ?source= as --ip-source
+//	POST /vm/{name}/{action}      start, shutdown, shutoff, soft-reboot, hard-reboot, pause or resume, ?mode= as --shutdown-mode or --reboot-mode
+//
+// Every endpoint but /health wants an "Authorization: Bearer <token>" header. An address without host listens on 127.0.0.1 only.
+func Serve(address string, tokenFile string) {
+	token, err := ReadServeToken(tokenFile)
+	if err != nil {
+		hfail(err)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		hfail(err)
+	}
+	if host == "" {
+		address = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", WithConnection(ServeHealth))
+	mux.HandleFunc("/vms", WithToken(token, WithConnection(ServeVirtualMachines)))
+	mux.HandleFunc("/vm/", WithToken(token, WithConnection(ServeVirtualMachine)))
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      2 * time.Minute,
+	}
+
+	Log(LevelInfo, "serving", "address", address)
+	hfail(server.ListenAndServe())
+}
+
+// ReadServeToken returns the bearer token of the http api from a file, or from the environment without one.
+func ReadServeToken(tokenFile string) (string, error) {
+	token := os.Getenv(serveTokenEnv)
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("--serve needs a bearer token, give it in --serve-token-file or %v", serveTokenEnv)
+	}
+	return token, nil
+}
+
+// WithToken lets through only requests carrying the bearer token, and refuses changes coming from another origin,
+// so a web page open in a local browser can not drive the api.
+func WithToken(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get("Authorization")
+		if !strings.HasPrefix(given, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(given, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			ServeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && IsCrossOrigin(r) {
+			ServeError(w, http.StatusForbidden, fmt.Errorf("%v from another origin is not allowed", r.Method))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// IsCrossOrigin tells whether a browser sent a request on behalf of a page from another origin.
+// Clients other than browsers send neither header and pass.
+func IsCrossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// WithConnection makes sure a request runs over a live connection, reconnecting when it died.
+func WithConnection(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := EnsureConnection(); err != nil {
+			ServeError(w, http.StatusServiceUnavailable, fmt.Errorf("no connection to %v: %v", *connectUri, err))
+			return
+		}
+
+		connectionMu.RLock()
+		defer connectionMu.RUnlock()
+		handler(w, r)
+	}
+}
+
+func ServeHealth(w http.ResponseWriter, r *http.Request) {
+	alive, err := libvirtInstance.IsAlive()
+	if err == nil && !alive {
+		err = errors.New("libvirt connection is dead")
+	}
+	if err != nil {
+		ServeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	ServeJSON(w, http.StatusOK, map[string]string{"ok": *connectUri})
+}
+
+func ServeVirtualMachines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ServeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v is not allowed on %v", r.Method, r.URL.Path))
+		return
+	}
+
+	AllDomains, err := libvirtInstance.ListAllDomains(0)
+	if err != nil {
+		ServeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	names := []string{}
+	for _, domain := range AllDomains {
+		if DomainName, err := domain.GetName(); err == nil {
+			names = append(names, DomainName)
+		}
+		domain.Free()
+	}
+
+	ret := make([]VirtualMachineListEntry, len(names))
+	ForEachParallel(len(names), *concurrency, func(i int) {
+		ret[i] = VirtualMachineListEntry{Name: names[i], VirtualMachineStateInfo: GetVirtualMachineStateInfo(names[i])}
+	})
+
+	ServeJSON(w, http.StatusOK, ret)
+}
+
+// ServeVirtualMachine handles everything under /vm/{name}/.
+func ServeVirtualMachine(w http.ResponseWriter, r *http.Request) {
+	vm, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/vm/"), "/")
+	if !ok || vm == "" || action == "" {
+		ServeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %v, use /vm/{name}/{action}", r.URL.Path))
+		return
+	}
+
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if IsLibvirtError(err, libvirt.ERR_NO_DOMAIN) {
+			status = http.StatusNotFound
+		}
+		ServeError(w, status, err)
+		return
+	}
+	defer d.Free()
+
+	switch {
+	case r.Method == http.MethodGet && action == "state":
+		ServeJSON(w, http.StatusOK, GetVirtualMachineStateInfo(vm))
+	case r.Method == http.MethodGet && action == "ips":
+		interfaces, err := GetVirtualMachineInterfaces(d, r.URL.Query().Get("source"))
+		if err != nil {
+			ServeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ServeJSON(w, http.StatusOK, VirtualMachineIpsOf(interfaces))
+	case r.Method == http.MethodPost && serverActions[action] != nil:
+		if *readonly {
+			ServeError(w, http.StatusForbidden, fmt.Errorf("%v changes a vm, the server runs with --readonly", action))
+			return
+		}
+		if err := serverActions[action](d, r); errors.Is(err, errBadRequest) {
+			ServeError(w, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			ServeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ServeJSON(w, http.StatusOK, GetVirtualMachineStateInfo(vm))
+	default:
+		ServeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %v %v", r.Method, r.URL.Path))
+	}
+}
+
+func ServeError(w http.ResponseWriter, status int, err error) {
+	ServeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func ServeJSON(w http.ResponseWriter, status int, i any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(i)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineInterfaceStats struct {
+	RxBytes   int64
+	RxPackets int64
+	RxErrs    int64
+	RxDrop    int64
+	TxBytes   int64
+	TxPackets int64
+	TxErrs    int64
+	TxDrop    int64
+}
+
+// VirtualMachineNetStats returns traffic counters for every interface of a VM, keyed by target dev name.
+func VirtualMachineNetStats(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	ret, err := GetVirtualMachineNetStats(d)
+	herr(err)
+	if err != nil {
+		return
+	}
+	hret(ret)
+}
+
+func GetVirtualMachineNetStats(d *libvirt.Domain) (map[string]VirtualMachineInterfaceStats, error) {
+
+	NetStats := make(map[string]VirtualMachineInterfaceStats)
+
+	DomXML, err := GetDomainXML(d, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range DomXML.Devices.Interfaces {
+		// interfaces of an inactive domain have no target device, and nothing to count either.
+		if iface.Target.Dev == "" {
+			continue
+		}
+
+		// an interface unplugged meanwhile has nothing to count, the others still do.
+		stats, err := d.InterfaceStats(iface.Target.Dev)
+		if err != nil {
+			Log(LevelWarn, "no interface stats", "dev", iface.Target.Dev, "error", err)
+			continue
+		}
+
+		NetStats[iface.Target.Dev] = VirtualMachineInterfaceStats{
+			RxBytes:   stats.RxBytes,
+			RxPackets: stats.RxPackets,
+			RxErrs:    stats.RxErrs,
+			RxDrop:    stats.RxDrop,
+			TxBytes:   stats.TxBytes,
+			TxPackets: stats.TxPackets,
+			TxErrs:    stats.TxErrs,
+			TxDrop:    stats.TxDrop,
+		}
+	}
+
+	return NetStats, nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"libvirt.org/go/libvirt"
+)
+
+type StoragePoolInfo struct {
+	Name            string
+	Type            string
+	State           string
+	CapacityBytes   uint64
+	AllocationBytes uint64
+	AvailableBytes  uint64
+}
+
+// StoragePoolXML is a partial model of the libvirt storage pool XML.
+type StoragePoolXML struct {
+	Type   string `xml:"type,attr"`
+	Target struct {
+		Path string `xml:"path"`
+	} `xml:"target"`
+}
+
+var storagePoolStates = map[libvirt.StoragePoolState]string{
+	libvirt.STORAGE_POOL_INACTIVE:     "inactive",
+	libvirt.STORAGE_POOL_BUILDING:     "building",
+	libvirt.STORAGE_POOL_RUNNING:      "active",
+	libvirt.STORAGE_POOL_DEGRADED:     "degraded",
+	libvirt.STORAGE_POOL_INACCESSIBLE: "inaccessible",
+}
+
+// StoragePoolList returns all storage pools on host with their capacity.
+func StoragePoolList() {
+	AllPools, err := libvirtInstance.ListAllStoragePools(0)
+	herr(err)
+
+	ret := []StoragePoolInfo{}
+	for _, pool := range AllPools {
+		ret = append(ret, GetStoragePoolInfo(&pool))
+		pool.Free()
+	}
+
+	var rows [][]string
+	for _, info := range ret {
+		rows = append(rows, []string{info.Name, info.Type, info.State,
+			fmt.Sprint(info.CapacityBytes), fmt.Sprint(info.AllocationBytes), fmt.Sprint(info.AvailableBytes)})
+	}
+	htable(ret, []string{"NAME", "TYPE", "STATE", "CAPACITY", "ALLOCATION", "AVAILABLE"}, rows)
+}
+
+func GetStoragePoolInfo(pool *libvirt.StoragePool) StoragePoolInfo {
+	var PoolInfo StoragePoolInfo
+	var err error
+
+	PoolInfo.Name, err = pool.GetName()
+	herr(err)
+
+	info, err := pool.GetInfo()
+	herr(err)
+	if err == nil {
+		PoolInfo.State = storagePoolStates[info.State]
+		PoolInfo.CapacityBytes = info.Capacity
+		PoolInfo.AllocationBytes = info.Allocation
+		PoolInfo.AvailableBytes = info.Available
+	}
+
+	PoolXML, err := GetStoragePoolXML(pool)
+	herr(err)
+	PoolInfo.Type = PoolXML.Type
+
+	return PoolInfo
+}
+
+// GetStoragePoolXML fetches and parses the XML description of a storage pool.
+func GetStoragePoolXML(pool *libvirt.StoragePool) (StoragePoolXML, error) {
+	var PoolXML StoragePoolXML
+
+	desc, err := pool.GetXMLDesc(0)
+	if err != nil {
+		return PoolXML, err
+	}
+
+	err = xml.Unmarshal([]byte(desc), &PoolXML)
+	return PoolXML, err
+}
+
+type StorageVolumeInfo struct {
+	Name            string
+	Path            string
+	CapacityBytes   uint64
+	AllocationBytes uint64
+}
+
+// StorageVolumeList returns all volumes of a storage pool.
+func StorageVolumeList(poolName string) {
+	pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer pool.Free()
+
+	active, err := pool.IsActive()
+	herr(err)
+	if !active {
+		herr(fmt.Errorf("pool %v is inactive, start it and run --pool-refresh to see its volumes", poolName))
+		return
+	}
+
+	AllVolumes, err := pool.ListAllStorageVolumes(0)
+	herr(err)
+
+	ret := []StorageVolumeInfo{}
+	for _, volume := range AllVolumes {
+		ret = append(ret, GetStorageVolumeInfo(&volume))
+		volume.Free()
+	}
+
+	var rows [][]string
+	for _, info := range ret {
+		rows = append(rows, []string{info.Name, info.Path, fmt.Sprint(info.CapacityBytes), fmt.Sprint(info.AllocationBytes)})
+	}
+	htable(ret, []string{"NAME", "PATH", "CAPACITY", "ALLOCATION"}, rows)
+}
+
+func GetStorageVolumeInfo(volume *libvirt.StorageVol) StorageVolumeInfo {
+	var VolumeInfo StorageVolumeInfo
+	var err error
+
+	VolumeInfo.Name, err = volume.GetName()
+	herr(err)
+
+	VolumeInfo.Path, err = volume.GetPath()
+	herr(err)
+
+	info, err := volume.GetInfo()
+	herr(err)
+	if err == nil {
+		VolumeInfo.CapacityBytes = info.Capacity
+		VolumeInfo.AllocationBytes = info.Allocation
+	}
+
+	return VolumeInfo
+}
+
+// StorageVolumeXML is a partial model of the libvirt storage volume XML.
+type StorageVolumeXML struct {
+	XMLName  xml.Name `xml:"volume"`
+	Name     string   `xml:"name"`
+	Capacity struct {
+		Unit  string `xml:"unit,attr,omitempty"`
+		Value uint64 `xml:",chardata"`
+	} `xml:"capacity"`
+	Target struct {
+		Path   string            `xml:"path,omitempty"`
+		Format *StorageFormatXML `xml:"format"`
+	} `xml:"target"`
+	BackingStore *StorageBackingStoreXML `xml:"backingStore"`
+}
+
+type StorageBackingStoreXML struct {
+	Path   string            `xml:"path"`
+	Format *StorageFormatXML `xml:"format"`
+}
+
+type StorageFormatXML struct {
+	Type string `xml:"type,attr"`
+}
+
+// StorageVolumeCreate creates a new volume in a storage pool. A qcow2 volume can be layered on top of a backing volume,
+// given either by name within the same pool or by path.
+func StorageVolumeCreate(poolName string, volumeName string, size string, volumeFormat string, backingVol string) {
+	pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer pool.Free()
+
+	if volumeFormat != "qcow2" && volumeFormat != "raw" {
+		herr(fmt.Errorf("unsupported volume format %v, use qcow2 or raw", volumeFormat))
+		return
+	}
+
+	capacity, err := ParseSize(size)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	var VolXML StorageVolumeXML
+	VolXML.Name = volumeName
+	VolXML.Capacity.Unit = "bytes"
+	VolXML.Capacity.Value = capacity
+	VolXML.Target.Format = &StorageFormatXML{Type: volumeFormat}
+
+	if backingVol != "" {
+		if volumeFormat != "qcow2" {
+			herr(fmt.Errorf("only qcow2 volumes can have a backing volume"))
+			return
+		}
+
+		VolXML.BackingStore = &StorageBackingStoreXML{Path: backingVol}
+
+		if backing, err := pool.LookupStorageVolByName(backingVol); err == nil {
+			BackingXML, err := GetStorageVolumeXML(backing)
+			herr(err)
+			VolXML.BackingStore.Path = BackingXML.Target.Path
+			VolXML.BackingStore.Format = BackingXML.Target.Format
+			backing.Free()
+		}
+	}
+
+	desc, err := xml.Marshal(VolXML)
+	herr(err)
+
+	volume, err := pool.StorageVolCreateXML(string(desc), 0)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer volume.Free()
+
+	hret(GetStorageVolumeInfo(volume))
+}
+
+// GetStorageVolumeXML fetches and parses the XML description of a storage volume.
+func GetStorageVolumeXML(volume *libvirt.StorageVol) (StorageVolumeXML, error) {
+	var VolXML StorageVolumeXML
+
+	desc, err := volume.GetXMLDesc(0)
+	if err != nil {
+		return VolXML, err
+	}
+
+	err = xml.Unmarshal([]byte(desc), &VolXML)
+	return VolXML, err
+}
+
+type StorageVolumeDeleteInfo struct {
+	Name       string
+	Path       string
+	FreedBytes uint64
+	Wiped      bool
+}
+
+// StorageVolumeDelete deletes a storage volume, optionally wiping its data first.
+// Volumes used by a domain are only deleted when forced.
+func StorageVolumeDelete(poolName string, volumeName string, wipe bool, force bool, dryRun bool) {
+	pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer pool.Free()
+
+	volume, err := pool.LookupStorageVolByName(volumeName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer volume.Free()
+
+	ret := StorageVolumeDeleteInfo{Name: volumeName, Wiped: wipe}
+	info := GetStorageVolumeInfo(volume)
+	ret.Path = info.Path
+	ret.FreedBytes = info.AllocationBytes
+
+	users := FindStorageVolumeUsers(poolName, volumeName, info.Path)
+	if len(users) > 0 && !force {
+		herr(fmt.Errorf("volume %v is used by %v, pass --force to delete it anyway", volumeName, strings.Join(users, ", ")))
+		return
+	}
+
+	if dryRun {
+		plan := DryRunPlan{Action: "volume delete", Vms: []string{}, Volumes: []string{info.Path}}
+		plan.Vms = append(plan.Vms, users...)
+		if wipe {
+			plan.Steps = append(plan.Steps, "wipe "+info.Path)
+		}
+		plan.Steps = append(plan.Steps, fmt.Sprintf("delete %v, freeing %v bytes", info.Path, info.AllocationBytes))
+		hret(plan)
+	}
+
+	if wipe {
+		err = volume.Wipe(0)
+		herr(err)
+		if err != nil {
+			return
+		}
+	}
+
+	err = volume.Delete(0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(ret)
+}
+
+// FindStorageVolumeUsers returns names of domains that have a volume attached as a disk, either by path
+// or by pool and volume name. Both running and persistent configurations are checked.
+func FindStorageVolumeUsers(poolName string, volumeName string, path string) []string {
+	var users []string
+
+	AllDomains, err := libvirtInstance.ListAllDomains(0)
+	herr(err)
+
+	for _, domain := range AllDomains {
+		DomainName, err := domain.GetName()
+		herr(err)
+
+		for _, flags := range []libvirt.DomainXMLFlags{0, libvirt.DOMAIN_XML_INACTIVE} {
+			DomXML, err := GetDomainXML(&domain, flags)
+			if err != nil {
+				continue
+			}
+			if DomXML.UsesStorageVolume(poolName, volumeName, path) {
+				users = append(users, DomainName)
+				break
+			}
+		}
+		domain.Free()
+	}
+
+	return users
+}
+
+// StorageVolumeResize sets a new capacity of a storage volume, or grows it by size when delta is set.
+// Shrinking has to be asked for explicitly, as it destroys data at the end of the volume.
+func StorageVolumeResize(poolName string, volumeName string, size string, delta bool, shrink bool) {
+	pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer pool.Free()
+
+	volume, err := pool.LookupStorageVolByName(volumeName)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer volume.Free()
+
+	capacity, err := ParseSize(size)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	var flags libvirt.StorageVolResizeFlags
+	if delta {
+		flags |= libvirt.STORAGE_VOL_RESIZE_DELTA
+	}
+	if shrink {
+		flags |= libvirt.STORAGE_VOL_RESIZE_SHRINK
+	}
+
+	err = volume.Resize(capacity, flags)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(GetStorageVolumeInfo(volume))
+}
+
+type StoragePoolRefreshInfo struct {
+	Name       string
+	DurationMs int64
+}
+
+// StoragePoolRefresh rescans a storage pool for volumes added out of band. Without a pool name
+// every active pool is refreshed.
+func StoragePoolRefresh(poolName string) {
+	var AllPools []libvirt.StoragePool
+
+	if poolName != "" {
+		pool, err := libvirtInstance.LookupStoragePoolByName(poolName)
+		herr(err)
+		if err != nil {
+			return
+		}
+		AllPools = append(AllPools, *pool)
+	} else {
+		var err error
+		AllPools, err = libvirtInstance.ListAllStoragePools(libvirt.CONNECT_LIST_STORAGE_POOLS_ACTIVE)
+		herr(err)
+	}
+
+	ret := []StoragePoolRefreshInfo{}
+	for _, pool := range AllPools {
+		name, err := pool.GetName()
+		herr(err)
+
+		start := time.Now()
+		err = pool.Refresh(0)
+		herr(err)
+		if err == nil {
+			ret = append(ret, StoragePoolRefreshInfo{Name: name, DurationMs: time.Since(start).Milliseconds()})
+		}
+		pool.Free()
+	}
+
+	hret(ret)
+}
+
+// LookupDiskVolume finds the storage volume behind a domain disk, given either by pool and volume name or by path.
+func LookupDiskVolume(disk DomainDiskXML) (*libvirt.StorageVol, error) {
+	if disk.Source == nil {
+		return nil, fmt.Errorf("disk %v has no source", disk.Target.Dev)
+	}
+
+	if disk.Source.Pool != "" {
+		pool, err := libvirtInstance.LookupStoragePoolByName(disk.Source.Pool)
+		if err != nil {
+			return nil, err
+		}
+		defer pool.Free()
+		return pool.LookupStorageVolByName(disk.Source.Volume)
+	}
+
+	path := disk.Source.File
+	if path == "" {
+		path = disk.Source.Dev
+	}
+	if path == "" {
+		return nil, fmt.Errorf("disk %v is not backed by a local volume", disk.Target.Dev)
+	}
+
+	return libvirtInstance.LookupStorageVolByPath(path)
+}
+
+// FindStorageVolumeOverlays returns paths of volumes in active pools that are layered on top of a backing image.
+func FindStorageVolumeOverlays(path string) []string {
+	var overlays []string
+
+	AllPools, err := libvirtInstance.ListAllStoragePools(libvirt.CONNECT_LIST_STORAGE_POOLS_ACTIVE)
+	herr(err)
+
+	for _, pool := range AllPools {
+		AllVolumes, err := pool.ListAllStorageVolumes(0)
+		herr(err)
+
+		for _, volume := range AllVolumes {
+			desc, err := volume.GetXMLDesc(0)
+			var VolXML StorageVolumeXML
+			if err == nil && xml.Unmarshal([]byte(desc), &VolXML) == nil &&
+				VolXML.BackingStore != nil && VolXML.BackingStore.Path == path {
+				overlays = append(overlays, VolXML.Target.Path)
+			}
+			volume.Free()
+		}
+		pool.Free()
+	}
+
+	return overlays
+}
+
+// VirtualMachineOwnVolumes returns paths of the volumes behind the disks that go with a vm, leaving out those
+// another vm uses or an overlay image is layered on. Those are returned as shared and have to be kept.
+func VirtualMachineOwnVolumes(vm string, disks []DomainDiskXML) (own []string, shared []string) {
+	for _, disk := range disks {
+		if !disk.GoesWithVm() {
+			continue
+		}
+
+		volume, err := LookupDiskVolume(disk)
+		herr(err)
+		if err != nil {
+			continue
+		}
+
+		info := GetStorageVolumeInfo(volume)
+		poolName := ""
+		if pool, err := volume.LookupPoolByVolume(); err == nil {
+			poolName, _ = pool.GetName()
+			pool.Free()
+		}
+		volume.Free()
+
+		var needed []string
+		for _, user := range FindStorageVolumeUsers(poolName, info.Name, info.Path) {
+			if user != vm {
+				needed = append(needed, user)
+			}
+		}
+		needed = append(needed, FindStorageVolumeOverlays(info.Path)...)
+
+		if len(needed) > 0 {
+			Log(LevelWarn, "keeping volume still in use", "path", info.Path, "users", strings.Join(needed, ", "))
+			shared = append(shared, info.Path)
+			continue
+		}
+		own = append(own, info.Path)
+	}
+
+	return own, shared
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	templateDownloadTimeout = 30 * time.Second
+	templateMaxBytes        = 1 << 20
+)
+
+// ReadXMLTemplate reads an xml template from a file, or downloads it when the path is an http(s) url, e.g. from a central
+// template store. Callers check the result is xml, local and downloaded templates alike, so an error page of the store
+// never ends up as a vm definition.
+func ReadXMLTemplate(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return os.ReadFile(path)
+	}
+
+	client := http.Client{Timeout: templateDownloadTimeout}
+	resp, err := client.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %v: %v", path, resp.Status)
+	}
+
+	// one byte over the limit tells a template that is too big from one that just fits.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, templateMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %v: %v", path, err)
+	}
+	if len(data) > templateMaxBytes {
+		return nil, fmt.Errorf("%v is larger than %v bytes, that is no xml template", path, templateMaxBytes)
+	}
+
+	return data, nil
+}
+
+// RenderXMLTemplate reads an xml template file or url and renders it as a go text/template.
+// Values come from key=value pairs, fileValues are key=path pairs with the value read from the file.
+// Referencing a key that was not given is an error, so a half-rendered xml never reaches libvirt.
+// Values are xml escaped, so a & or < in them stays text and can not add elements.
+func RenderXMLTemplate(path string, values []string, fileValues []string) (string, error) {
+	data, err := ReadXMLTemplate(path)
+	if err != nil {
+		return "", err
+	}
+
+	vars := make(map[string]string)
+	for _, value := range values {
+		k, v, ok := strings.Cut(value, "=")
+		if !ok {
+			return "", fmt.Errorf("--set %v must look like key=value", value)
+		}
+		vars[k] = EscapeXMLText(v)
+	}
+	for _, value := range fileValues {
+		k, file, ok := strings.Cut(value, "=")
+		if !ok {
+			return "", fmt.Errorf("--set-file %v must look like key=path", value)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		vars[k] = EscapeXMLText(string(content))
+	}
+
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return "", fmt.Errorf("%v is not a valid template: %v", path, err)
+	}
+
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, vars); err != nil {
+		return "", fmt.Errorf("failed to render %v, pass the missing values with --set or --set-file: %v", path, err)
+	}
+
+	// checked once rendered, as the template itself need not be xml yet.
+	if err = CheckXML(path, rendered.Bytes()); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// EscapeXMLText escapes a value for use as xml text or attribute value.
+func EscapeXMLText(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderXMLTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "domain.xml")
+	if err := os.WriteFile(path, []byte(`<domain><name>{{.name}}</name><description title="{{.title}}">{{.description}}</description></domain>`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	description := filepath.Join(dir, "description.txt")
+	if err := os.WriteFile(description, []byte("a</description><devices/>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		values     []string
+		fileValues []string
+		want       string
+		wantErr    bool
+	}{
+		{
+			values: []string{"name=vm1", "title=web", "description=plain"},
+			want:   `<domain><name>vm1</name><description title="web">plain</description></domain>`,
+		},
+		{
+			values: []string{"name=a&b", `title=say "hi"`, "description=x<y"},
+			want:   `<domain><name>a&amp;b</name><description title="say &#34;hi&#34;">x&lt;y</description></domain>`,
+		},
+		{
+			values:     []string{"name=vm1", "title=web"},
+			fileValues: []string{"description=" + description},
+			want:       `<domain><name>vm1</name><description title="web">a&lt;/description&gt;&lt;devices/&gt;</description></domain>`,
+		},
+		{
+			values: []string{"name=a=b", "title=web", "description=plain"},
+			want:   `<domain><name>a=b</name><description title="web">plain</description></domain>`,
+		},
+		{values: []string{"name=vm1", "title=web"}, wantErr: true},
+		{values: []string{"name"}, wantErr: true},
+		{values: []string{"name=vm1", "title=web"}, fileValues: []string{"description=" + filepath.Join(dir, "missing")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := RenderXMLTemplate(path, tt.values, tt.fileValues)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RenderXMLTemplate(%q, %q) error = %v, want error %v", tt.values, tt.fileValues, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("RenderXMLTemplate(%q, %q) = %v, want %v", tt.values, tt.fileValues, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+type VirtualMachineScheduler struct {
+	CpuShares uint64
+	// CpuQuota is how many microseconds each vcpu may run within CpuPeriod, negative is unlimited.
+	CpuQuota  int64
+	CpuPeriod uint64
+}
+
+// VirtualMachineSetScheduler changes cpu shares, quota and period of a VM. Parameters that are nil are left as they are.
+func VirtualMachineSetScheduler(vm string, shares *uint64, quota *int64, period *uint64) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var params libvirt.DomainSchedulerParameters
+	if shares != nil {
+		params.CpuSharesSet, params.CpuShares = true, *shares
+	}
+	if quota != nil {
+		params.VcpuQuotaSet, params.VcpuQuota = true, *quota
+	}
+	if period != nil {
+		params.VcpuPeriodSet, params.VcpuPeriod = true, *period
+	}
+
+	err = d.SetSchedulerParametersFlags(&params, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetScheduler(vm)
+}
+
+// VirtualMachineGetScheduler returns cpu shares, quota and period of a VM.
+func VirtualMachineGetScheduler(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	params, err := d.GetSchedulerParametersFlags(QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(VirtualMachineScheduler{CpuShares: params.CpuShares, CpuQuota: params.VcpuQuota, CpuPeriod: params.VcpuPeriod})
+}
+
+type VirtualMachineBlkio struct {
+	Weight  uint
+	Devices []VirtualMachineBlkioDevice
+}
+
+type VirtualMachineBlkioDevice struct {
+	Path          string
+	Weight        uint64
+	ReadIops      uint64
+	WriteIops     uint64
+	ReadBytesSec  uint64
+	WriteBytesSec uint64
+}
+
+// VirtualMachineSetBlkio changes the block io weight of a VM and io limits of one of its host block devices.
+// The device has to back a disk of the VM, unless forced. Parameters that are nil or empty are left as they are.
+func VirtualMachineSetBlkio(vm string, weight *uint64, devicePath string, readIops *uint64, writeIops *uint64, force bool) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var params libvirt.DomainBlkioParameters
+	if weight != nil {
+		params.WeightSet, params.Weight = true, uint(*weight)
+	}
+
+	if devicePath != "" {
+		if !force {
+			DomXML, err := GetDomainXML(d, 0)
+			herr(err)
+			if err != nil {
+				return
+			}
+			if !DomXML.UsesBlockDevice(devicePath) {
+				herr(fmt.Errorf("%v does not back any disk of %v, pass --force to limit it anyway", devicePath, vm))
+				return
+			}
+		}
+
+		if readIops != nil {
+			params.DeviceReadIopsSet, params.DeviceReadIops = true, fmt.Sprintf("%v,%d", devicePath, *readIops)
+		}
+		if writeIops != nil {
+			params.DeviceWriteIopsSet, params.DeviceWriteIops = true, fmt.Sprintf("%v,%d", devicePath, *writeIops)
+		}
+	}
+
+	err = d.SetBlkioParameters(&params, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetBlkio(vm)
+}
+
+// VirtualMachineGetBlkio returns the block io weight of a VM and io limits of its host block devices.
+func VirtualMachineGetBlkio(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	params, err := d.GetBlkioParameters(QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	weights := ParseBlkioDeviceValues(params.DeviceWeight)
+	readIops := ParseBlkioDeviceValues(params.DeviceReadIops)
+	writeIops := ParseBlkioDeviceValues(params.DeviceWriteIops)
+	readBps := ParseBlkioDeviceValues(params.DeviceReadBps)
+	writeBps := ParseBlkioDeviceValues(params.DeviceWriteBps)
+
+	var paths []string
+	for _, values := range []map[string]uint64{weights, readIops, writeIops, readBps, writeBps} {
+		for path := range values {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	ret := VirtualMachineBlkio{Weight: params.Weight, Devices: []VirtualMachineBlkioDevice{}}
+	for i, path := range paths {
+		if i > 0 && paths[i-1] == path {
+			continue
+		}
+		ret.Devices = append(ret.Devices, VirtualMachineBlkioDevice{
+			Path:          path,
+			Weight:        weights[path],
+			ReadIops:      readIops[path],
+			WriteIops:     writeIops[path],
+			ReadBytesSec:  readBps[path],
+			WriteBytesSec: writeBps[path],
+		})
+	}
+
+	hret(ret)
+}
+
+// ParseBlkioDeviceValues splits per device blkio values, which libvirt reports as a flat list: path,value,path,value...
+func ParseBlkioDeviceValues(list string) map[string]uint64 {
+	values := map[string]uint64{}
+
+	fields := strings.Split(list, ",")
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseUint(fields[i+1], 10, 64)
+		herr(err)
+		values[fields[i]] = value
+	}
+
+	return values
+}
+
+type VirtualMachineMemtune struct {
+	// limits are nil when unlimited.
+	HardLimitBytes     *uint64
+	SoftLimitBytes     *uint64
+	SwapHardLimitBytes *uint64
+}
+
+// ParseMemoryLimit converts a size, or "unlimited", into KiB which memory parameters are set in.
+func ParseMemoryLimit(limit string) (uint64, error) {
+	if limit == "unlimited" {
+		return libvirt.DOMAIN_MEMORY_PARAM_UNLIMITED, nil
+	}
+
+	bytes, err := ParseSize(limit)
+	return (bytes + 1023) / 1024, err
+}
+
+// memoryLimitBytes converts a memory parameter from KiB back into bytes, nil for unlimited.
+func memoryLimitBytes(kib uint64) *uint64 {
+	if kib >= libvirt.DOMAIN_MEMORY_PARAM_UNLIMITED {
+		return nil
+	}
+	bytes := kib * 1024
+	return &bytes
+}
+
+// VirtualMachineSetMemtune changes how much host memory a VM may use. Limits that are nil are left as they are.
+func VirtualMachineSetMemtune(vm string, hardLimit *string, softLimit *string, swapHardLimit *string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var params libvirt.DomainMemoryParameters
+	if hardLimit != nil {
+		params.HardLimitSet = true
+		params.HardLimit, err = ParseMemoryLimit(*hardLimit)
+		herr(err)
+	}
+	if softLimit != nil && err == nil {
+		params.SoftLimitSet = true
+		params.SoftLimit, err = ParseMemoryLimit(*softLimit)
+		herr(err)
+	}
+	if swapHardLimit != nil && err == nil {
+		params.SwapHardLimitSet = true
+		params.SwapHardLimit, err = ParseMemoryLimit(*swapHardLimit)
+		herr(err)
+	}
+	if err != nil {
+		return
+	}
+
+	err = d.SetMemoryParameters(&params, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetMemtune(vm)
+}
+
+// VirtualMachineGetMemtune returns memory limits of a VM in bytes.
+func VirtualMachineGetMemtune(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	params, err := d.GetMemoryParameters(QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(VirtualMachineMemtune{
+		HardLimitBytes:     memoryLimitBytes(params.HardLimit),
+		SoftLimitBytes:     memoryLimitBytes(params.SoftLimit),
+		SwapHardLimitBytes: memoryLimitBytes(params.SwapHardLimit),
+	})
+}
+
+type VirtualMachineDiskIotune struct {
+	TargetDev     string
+	TotalBytesSec uint64
+	ReadBytesSec  uint64
+	WriteBytesSec uint64
+	TotalIopsSec  uint64
+	ReadIopsSec   uint64
+	WriteIopsSec  uint64
+}
+
+// VirtualMachineSetDiskIotune throttles a disk of a VM, by bytes and by operations per second. 0 lifts a limit,
+// limits that are nil are left as they are.
+func VirtualMachineSetDiskIotune(vm string, targetDev string, totalBytes *string, readBytes *string, writeBytes *string,
+	totalIops *uint64, readIops *uint64, writeIops *uint64) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var params libvirt.DomainBlockIoTuneParameters
+	if totalBytes != nil {
+		params.TotalBytesSecSet = true
+		params.TotalBytesSec, err = ParseSize(*totalBytes)
+		herr(err)
+	}
+	if readBytes != nil && err == nil {
+		params.ReadBytesSecSet = true
+		params.ReadBytesSec, err = ParseSize(*readBytes)
+		herr(err)
+	}
+	if writeBytes != nil && err == nil {
+		params.WriteBytesSecSet = true
+		params.WriteBytesSec, err = ParseSize(*writeBytes)
+		herr(err)
+	}
+	if err != nil {
+		return
+	}
+
+	if totalIops != nil {
+		params.TotalIopsSecSet, params.TotalIopsSec = true, *totalIops
+	}
+	if readIops != nil {
+		params.ReadIopsSecSet, params.ReadIopsSec = true, *readIops
+	}
+	if writeIops != nil {
+		params.WriteIopsSecSet, params.WriteIopsSec = true, *writeIops
+	}
+
+	err = d.SetBlockIoTune(targetDev, &params, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetDiskIotune(vm, targetDev)
+}
+
+// VirtualMachineGetDiskIotune returns throttling limits of a disk of a VM.
+func VirtualMachineGetDiskIotune(vm string, targetDev string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	params, err := d.GetBlockIoTune(targetDev, QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(VirtualMachineDiskIotune{
+		TargetDev:     targetDev,
+		TotalBytesSec: params.TotalBytesSec,
+		ReadBytesSec:  params.ReadBytesSec,
+		WriteBytesSec: params.WriteBytesSec,
+		TotalIopsSec:  params.TotalIopsSec,
+		ReadIopsSec:   params.ReadIopsSec,
+		WriteIopsSec:  params.WriteIopsSec,
+	})
+}
+
+type VirtualMachineIOThreads struct {
+	Count int
+	Disks []VirtualMachineDiskIOThread
+	// RestartNeeded is set while the vm runs, it gets the iothreads from its next start.
+	RestartNeeded bool
+}
+
+type VirtualMachineDiskIOThread struct {
+	TargetDev string
+	// IOThread is 0 for disks served by the main qemu thread.
+	IOThread int
+}
+
+// diskIOThreadDriver returns the driver element holding the iothread of a disk: its own for virtio-blk, that of its
+// virtio-scsi controller for scsi. nil when the disk bus has no iothreads. A missing driver element is added
+// when create is set, an empty one is returned otherwise.
+func diskIOThreadDriver(devices *XMLNode, disk *XMLNode, create bool) *XMLNode {
+	driver := func(parent *XMLNode) *XMLNode {
+		if create {
+			return parent.EnsureChild("driver")
+		}
+		if child := parent.Child("driver"); child != nil {
+			return child
+		}
+		return &XMLNode{}
+	}
+
+	target := disk.Child("target")
+	if target == nil {
+		return nil
+	}
+
+	switch target.Attr("bus") {
+	case "virtio":
+		return driver(disk)
+	case "scsi":
+		index := "0"
+		if address := disk.Child("address"); address != nil && address.Attr("controller") != "" {
+			index = address.Attr("controller")
+		}
+		for _, controller := range devices.Children("controller") {
+			if controller.Attr("type") == "scsi" && controller.Attr("index") == index && controller.Attr("model") == "virtio-scsi" {
+				return driver(controller)
+			}
+		}
+	}
+	return nil
+}
+
+// VirtualMachineSetIOThreads sets how many iothreads a VM has and which iothread serves which disk, given as
+// target=iothread, e.g. vda=1. Only virtio disks and disks on a virtio-scsi controller, which all its disks share,
+// can have one. Nil count keeps the current one.
+func VirtualMachineSetIOThreads(vm string, count *uint, assignments []string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	DiskIOThreads := map[string]int{}
+	for _, assignment := range assignments {
+		target, value, ok := strings.Cut(assignment, "=")
+		iothread, err := strconv.Atoi(value)
+		if !ok || err != nil || iothread < 0 {
+			herr(fmt.Errorf("bad --assign-iothread %v, use target=iothread, e.g. vda=1", assignment))
+			return
+		}
+		DiskIOThreads[target] = iothread
+	}
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		IOThreads, _ := strconv.Atoi(strings.TrimSpace(DomXML.EnsureChild("iothreads").Text))
+		if count != nil {
+			IOThreads = int(*count)
+		}
+		DomXML.SetChildText("iothreads", strconv.Itoa(IOThreads))
+
+		devices := DomXML.EnsureChild("devices")
+		found := map[string]bool{}
+		for _, disk := range devices.Children("disk") {
+			target := disk.Child("target")
+			if target == nil {
+				continue
+			}
+			iothread, ok := DiskIOThreads[target.Attr("dev")]
+			if !ok {
+				continue
+			}
+			found[target.Attr("dev")] = true
+
+			driver := diskIOThreadDriver(devices, disk, true)
+			if driver == nil {
+				return fmt.Errorf("%v is on bus %v, only virtio and virtio-scsi disks have iothreads", target.Attr("dev"), target.Attr("bus"))
+			}
+			if iothread > IOThreads {
+				return fmt.Errorf("%v can not use iothread %v, %v has %v", target.Attr("dev"), iothread, vm, IOThreads)
+			}
+			if iothread == 0 {
+				driver.SetAttr("iothread", "")
+			} else {
+				driver.SetAttr("iothread", strconv.Itoa(iothread))
+			}
+		}
+		for target := range DiskIOThreads {
+			if !found[target] {
+				return fmt.Errorf("%v has no disk %v", vm, target)
+			}
+		}
+
+		// disks left on iothreads that are gone would keep the vm from starting.
+		for _, disk := range devices.Children("disk") {
+			if driver := diskIOThreadDriver(devices, disk, false); driver != nil {
+				if iothread, _ := strconv.Atoi(driver.Attr("iothread")); iothread > IOThreads {
+					return fmt.Errorf("%v uses iothread %v, assign it another one too", disk.Child("target").Attr("dev"), iothread)
+				}
+			}
+		}
+		if IOThreads == 0 {
+			DomXML.RemoveChildren("iothreads")
+		}
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetIOThreads(d)
+}
+
+// VirtualMachineGetIOThreads returns the iothreads of a VM and the disks they serve.
+func VirtualMachineGetIOThreads(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineIOThreads{Disks: []VirtualMachineDiskIOThread{}, RestartNeeded: RestartNeeded(d)}
+	if iothreads := DomXML.Child("iothreads"); iothreads != nil {
+		ret.Count, _ = strconv.Atoi(strings.TrimSpace(iothreads.Text))
+	}
+
+	devices := DomXML.EnsureChild("devices")
+	for _, disk := range devices.Children("disk") {
+		if driver := diskIOThreadDriver(devices, disk, false); driver != nil {
+			iothread, _ := strconv.Atoi(driver.Attr("iothread"))
+			ret.Disks = append(ret.Disks, VirtualMachineDiskIOThread{TargetDev: disk.Child("target").Attr("dev"), IOThread: iothread})
+		}
+	}
+
+	hret(ret)
+}
+
+// ParseCpuSet parses a cpu or numa node list as libvirt writes it, e.g. 0-3,6,^2, into a map of size entries.
+// Entries from size on are refused, they do not exist on the host.
+func ParseCpuSet(list string, size uint) ([]bool, error) {
+	set := make([]bool, size)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		exclude := strings.HasPrefix(part, "^")
+		part = strings.TrimPrefix(part, "^")
+
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		start, err1 := strconv.ParseUint(first, 10, 32)
+		end, err2 := strconv.ParseUint(last, 10, 32)
+		if err1 != nil || err2 != nil || start > end {
+			return nil, fmt.Errorf("bad cpu set %v, use a list like 0-3,6 or 0-7,^4", list)
+		}
+		if end >= uint64(size) && size == 0 {
+			return nil, fmt.Errorf("%v is out of %v, the host has none", end, list)
+		}
+		if end >= uint64(size) {
+			return nil, fmt.Errorf("%v is out of %v, the host has 0-%v", end, list, size-1)
+		}
+
+		for i := start; i <= end; i++ {
+			set[i] = !exclude
+		}
+	}
+	return set, nil
+}
+
+// FormatCpuSet writes a cpu map as a list the way libvirt does, e.g. 0-3,6.
+func FormatCpuSet(set []bool) string {
+	var parts []string
+	for i := 0; i < len(set); i++ {
+		if !set[i] {
+			continue
+		}
+		start := i
+		for i+1 < len(set) && set[i+1] {
+			i++
+		}
+		if start == i {
+			parts = append(parts, strconv.Itoa(i))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v-%v", start, i))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// CheckNumaNodeset makes sure a nodeset only names host numa cells and names at least one of them.
+func CheckNumaNodeset(nodeset string, cells []int) error {
+	size := 0
+	for _, cell := range cells {
+		if cell >= size {
+			size = cell + 1
+		}
+	}
+
+	// a node past the last cell is refused by parsing already, gaps in between are checked after.
+	set, err := ParseCpuSet(nodeset, uint(size))
+	if err != nil {
+		return fmt.Errorf("bad numa nodeset: %v", err)
+	}
+	if FormatCpuSet(set) == "" {
+		return fmt.Errorf("%v leaves no numa node to place memory on", nodeset)
+	}
+
+	unknown := append([]bool{}, set...)
+	for _, cell := range cells {
+		unknown[cell] = false
+	}
+	if rest := FormatCpuSet(unknown); rest != "" {
+		return fmt.Errorf("the host has no numa node %v", rest)
+	}
+	return nil
+}
+
+type VirtualMachineNumatune struct {
+	Mode    string
+	Nodeset string
+}
+
+var numatuneModes = map[string]libvirt.DomainNumatuneMemMode{
+	"strict":      libvirt.DOMAIN_NUMATUNE_MEM_STRICT,
+	"preferred":   libvirt.DOMAIN_NUMATUNE_MEM_PREFERRED,
+	"interleave":  libvirt.DOMAIN_NUMATUNE_MEM_INTERLEAVE,
+	"restrictive": libvirt.DOMAIN_NUMATUNE_MEM_RESTRICTIVE,
+}
+
+// VirtualMachineSetNumatune places the memory of a VM on host numa nodes. Empty mode or nodeset is left as it is.
+// A running VM only takes a new nodeset in strict mode with a hypervisor that can move memory, see --persistent.
+func VirtualMachineSetNumatune(vm string, mode string, nodeset string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var params libvirt.DomainNumaParameters
+	if mode != "" {
+		NumaMode, ok := numatuneModes[mode]
+		if !ok {
+			herr(fmt.Errorf("unknown numa mode %v, use strict, preferred, interleave or restrictive", mode))
+			return
+		}
+		params.ModeSet, params.Mode = true, NumaMode
+	}
+
+	if nodeset != "" {
+		// node info counts cells unreliably, the capabilities list their actual ids.
+		cells, err := GetHostNumaCells()
+		herr(err)
+		if err != nil {
+			return
+		}
+		if err = CheckNumaNodeset(nodeset, cells); err != nil {
+			herr(err)
+			return
+		}
+		params.NodesetSet, params.Nodeset = true, nodeset
+	}
+
+	err = d.SetNumaParameters(&params, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetNumatune(vm)
+}
+
+// VirtualMachineGetNumatune returns how the memory of a VM is placed on host numa nodes.
+func VirtualMachineGetNumatune(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	params, err := d.GetNumaParameters(QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineNumatune{Nodeset: params.Nodeset}
+	for name, mode := range numatuneModes {
+		if params.ModeSet && mode == params.Mode {
+			ret.Mode = name
+		}
+	}
+
+	hret(ret)
+}
+
+type VirtualMachineEmulatorPin struct {
+	// CpuSet lists the host cpus the emulator threads of the vm may run on, e.g. 0-1.
+	CpuSet string
+}
+
+// VirtualMachinePinEmulator confines the emulator threads of a VM, which do io and housekeeping, to host cpus,
+// so they stay off the cpus its vcpus run on.
+func VirtualMachinePinEmulator(vm string, cpuSet string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	HostCpus, _, err := libvirtInstance.GetCPUMap(0)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	cpumap, err := ParseCpuSet(cpuSet, uint(len(HostCpus)))
+	herr(err)
+	if err != nil {
+		return
+	}
+	if FormatCpuSet(cpumap) == "" {
+		herr(fmt.Errorf("%v leaves the emulator no cpu to run on", cpuSet))
+		return
+	}
+
+	err = d.PinEmulator(cpumap, ModificationImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetEmulatorPin(vm)
+}
+
+// VirtualMachineGetEmulatorPin returns the host cpus the emulator threads of a VM may run on.
+func VirtualMachineGetEmulatorPin(vm string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	cpumap, err := d.GetEmulatorPinInfo(QueryImpact())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(VirtualMachineEmulatorPin{CpuSet: FormatCpuSet(cpumap)})
+}
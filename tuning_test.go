@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCpuSet(t *testing.T) {
+	tests := []struct {
+		list    string
+		size    uint
+		want    []bool
+		wantErr bool
+	}{
+		{list: "0", size: 4, want: []bool{true, false, false, false}},
+		{list: "0-3", size: 4, want: []bool{true, true, true, true}},
+		{list: "1,3", size: 4, want: []bool{false, true, false, true}},
+		{list: " 0-1 , 3 ", size: 4, want: []bool{true, true, false, true}},
+		{list: "0-3,^2", size: 4, want: []bool{true, true, false, true}},
+		{list: "0-5,^1-3", size: 6, want: []bool{true, false, false, false, true, true}},
+		// entries apply in order, same as in libvirt, so an exclusion only drops what comes before it.
+		{list: "^2,0-3", size: 4, want: []bool{true, true, true, true}},
+		{list: "0-3,^0-3", size: 4, want: []bool{false, false, false, false}},
+		{list: "3", size: 4, want: []bool{false, false, false, true}},
+		{list: "4", size: 4, wantErr: true},
+		{list: "0-4", size: 4, wantErr: true},
+		{list: "0-3,^7", size: 4, wantErr: true},
+		{list: "0", size: 0, wantErr: true},
+		{list: "", size: 4, wantErr: true},
+		{list: "0,", size: 4, wantErr: true},
+		{list: "3-1", size: 4, wantErr: true},
+		{list: "-1", size: 4, wantErr: true},
+		{list: "0-", size: 4, wantErr: true},
+		{list: "^", size: 4, wantErr: true},
+		{list: "a-b", size: 4, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCpuSet(tt.list, tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCpuSet(%q, %v) error = %v, want error %v", tt.list, tt.size, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCpuSet(%q, %v) = %v, want %v", tt.list, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCheckNumaNodeset(t *testing.T) {
+	tests := []struct {
+		nodeset string
+		cells   []int
+		wantErr bool
+	}{
+		{nodeset: "0", cells: []int{0}},
+		{nodeset: "0-1", cells: []int{0, 1}},
+		{nodeset: "0-3,^1", cells: []int{0, 1, 2, 3}},
+		{nodeset: "0,2", cells: []int{0, 2}},
+		// cell 1 is missing on the host, so a range over it is refused.
+		{nodeset: "0-2", cells: []int{0, 2}, wantErr: true},
+		{nodeset: "1", cells: []int{0, 2}, wantErr: true},
+		{nodeset: "2", cells: []int{0, 1}, wantErr: true},
+		{nodeset: "0-1,^0-1", cells: []int{0, 1}, wantErr: true},
+		{nodeset: "0", cells: nil, wantErr: true},
+		{nodeset: "", cells: []int{0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := CheckNumaNodeset(tt.nodeset, tt.cells)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckNumaNodeset(%q, %v) error = %v, want error %v", tt.nodeset, tt.cells, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatCpuSet(t *testing.T) {
+	tests := []struct {
+		set  []bool
+		want string
+	}{
+		{set: nil, want: ""},
+		{set: []bool{false, false}, want: ""},
+		{set: []bool{true}, want: "0"},
+		{set: []bool{true, true, true, true}, want: "0-3"},
+		{set: []bool{true, true, false, true}, want: "0-1,3"},
+		{set: []bool{false, true, false, true, false}, want: "1,3"},
+		{set: []bool{true, false, true, true, true, false, false, true, true}, want: "0,2-4,7-8"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatCpuSet(tt.set); got != tt.want {
+			t.Errorf("FormatCpuSet(%v) = %q, want %q", tt.set, got, tt.want)
+		}
+	}
+
+	// formatting and parsing again gives the same set back.
+	for _, list := range []string{"0-3,6", "1,3,5-7", "0-7,^4"} {
+		set, err := ParseCpuSet(list, 8)
+		if err != nil {
+			t.Errorf("ParseCpuSet(%q, 8) error = %v", list, err)
+			continue
+		}
+		again, err := ParseCpuSet(FormatCpuSet(set), 8)
+		if err != nil || !reflect.DeepEqual(again, set) {
+			t.Errorf("ParseCpuSet(FormatCpuSet(%q)) = %v, %v, want %v", list, again, err, set)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kib": 1 << 10,
+	"kb":  1000,
+	"m":   1 << 20,
+	"mib": 1 << 20,
+	"mb":  1000 * 1000,
+	"g":   1 << 30,
+	"gib": 1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"t":   1 << 40,
+	"tib": 1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+}
+
+// ParseSize converts a human readable size like "512M", "20GiB" or "1TB" into bytes.
+// Single letter suffixes are binary, same as in virsh. A bare number is taken as bytes.
+func ParseSize(size string) (uint64, error) {
+	size = strings.TrimSpace(size)
+
+	i := 0
+	for i < len(size) && size[i] >= '0' && size[i] <= '9' {
+		i++
+	}
+
+	multiplier, ok := sizeSuffixes[strings.ToLower(strings.TrimSpace(size[i:]))]
+	if i == 0 || !ok {
+		return 0, fmt.Errorf("invalid size %v", size)
+	}
+
+	value, err := strconv.ParseUint(size[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %v", size)
+	}
+
+	if value > ^uint64(0)/multiplier {
+		return 0, fmt.Errorf("size %v is too large", size)
+	}
+
+	return value * multiplier, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    uint64
+		wantErr bool
+	}{
+		{size: "0", want: 0},
+		{size: "512", want: 512},
+		{size: "512b", want: 512},
+		{size: "1k", want: 1 << 10},
+		{size: "1KiB", want: 1 << 10},
+		{size: "1kb", want: 1000},
+		{size: "512M", want: 512 << 20},
+		{size: "20GiB", want: 20 << 30},
+		{size: "20GB", want: 20 * 1000 * 1000 * 1000},
+		{size: "1T", want: 1 << 40},
+		{size: "1TB", want: 1000 * 1000 * 1000 * 1000},
+		{size: " 10 G ", want: 10 << 30},
+		{size: "", wantErr: true},
+		{size: "G", wantErr: true},
+		{size: "-1G", wantErr: true},
+		{size: "1.5G", wantErr: true},
+		{size: "10X", wantErr: true},
+		{size: "99999999999999999999", wantErr: true},
+		{size: "16777216T", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, want error %v", tt.size, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
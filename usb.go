@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+type UsbDevice struct {
+	// Name is the node device name, e.g. usb_1_4.
+	Name      string
+	Bus       uint
+	Device    uint
+	VendorId  string
+	ProductId string
+	Vendor    string
+	Product   string
+}
+
+// NodeDeviceUsbXML is a partial model of the node device xml of a host usb device.
+type NodeDeviceUsbXML struct {
+	Name       string `xml:"name"`
+	Capability struct {
+		Bus     uint `xml:"bus"`
+		Device  uint `xml:"device"`
+		Product struct {
+			Id   string `xml:"id,attr"`
+			Name string `xml:",chardata"`
+		} `xml:"product"`
+		Vendor struct {
+			Id   string `xml:"id,attr"`
+			Name string `xml:",chardata"`
+		} `xml:"vendor"`
+	} `xml:"capability"`
+}
+
+// UsbHostdevXML is a hostdev fragment passing a host usb device through, by vendor and product or by address.
+type UsbHostdevXML struct {
+	XMLName xml.Name `xml:"hostdev"`
+	Mode    string   `xml:"mode,attr"`
+	Type    string   `xml:"type,attr"`
+	Managed string   `xml:"managed,attr"`
+	Source  struct {
+		Vendor *struct {
+			Id string `xml:"id,attr"`
+		} `xml:"vendor"`
+		Product *struct {
+			Id string `xml:"id,attr"`
+		} `xml:"product"`
+		Address *struct {
+			Bus    uint `xml:"bus,attr"`
+			Device uint `xml:"device,attr"`
+		} `xml:"address"`
+	} `xml:"source"`
+}
+
+// ParseUsbId parses a hex vendor or product id, with or without 0x, into the form libvirt writes, e.g. 0x046d.
+func ParseUsbId(id string) (string, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(id), "0x"), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad usb id %v, use hex as shown by lsusb, e.g. 046d", id)
+	}
+	return fmt.Sprintf("0x%04x", value), nil
+}
+
+// ParseUsbAddress parses a usb address in the bus:device form, e.g. 001:004 as shown by lsusb.
+func ParseUsbAddress(address string) (uint, uint, error) {
+	bus, device, ok := strings.Cut(address, ":")
+	BusNum, err1 := strconv.ParseUint(bus, 10, 32)
+	DeviceNum, err2 := strconv.ParseUint(device, 10, 32)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("bad usb address %v, use bus:device, e.g. 001:004", address)
+	}
+	return uint(BusNum), uint(DeviceNum), nil
+}
+
+// FindUsbDevices returns host usb devices matching a vendor and product id, or an address. Empty values match anything.
+func FindUsbDevices(vendor string, product string, address string) ([]UsbDevice, error) {
+	var bus, device uint
+	var err error
+	if address != "" {
+		if bus, device, err = ParseUsbAddress(address); err != nil {
+			return nil, err
+		}
+	}
+	if vendor != "" {
+		if vendor, err = ParseUsbId(vendor); err != nil {
+			return nil, err
+		}
+	}
+	if product != "" {
+		if product, err = ParseUsbId(product); err != nil {
+			return nil, err
+		}
+	}
+
+	AllDevices, err := libvirtInstance.ListAllNodeDevices(libvirt.CONNECT_LIST_NODE_DEVICES_CAP_USB_DEV)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []UsbDevice{}
+	for _, dev := range AllDevices {
+		desc, err := dev.GetXMLDesc(0)
+		dev.Free()
+		if err != nil {
+			continue
+		}
+
+		var DevXML NodeDeviceUsbXML
+		if err = xml.Unmarshal([]byte(desc), &DevXML); err != nil {
+			continue
+		}
+		UsbDev := UsbDevice{
+			Name:      DevXML.Name,
+			Bus:       DevXML.Capability.Bus,
+			Device:    DevXML.Capability.Device,
+			VendorId:  DevXML.Capability.Vendor.Id,
+			ProductId: DevXML.Capability.Product.Id,
+			Vendor:    strings.TrimSpace(DevXML.Capability.Vendor.Name),
+			Product:   strings.TrimSpace(DevXML.Capability.Product.Name),
+		}
+
+		if (vendor == "" || UsbDev.VendorId == vendor) && (product == "" || UsbDev.ProductId == product) &&
+			(address == "" || (UsbDev.Bus == bus && UsbDev.Device == device)) {
+			ret = append(ret, UsbDev)
+		}
+	}
+	return ret, nil
+}
+
+// UsbHostdev returns a hostdev fragment for a host usb device. Vendor and product survive the device being plugged
+// into another port, an address tells apart several devices of the same kind.
+func UsbHostdev(dev UsbDevice, byAddress bool) (string, error) {
+	Hostdev := UsbHostdevXML{Mode: "subsystem", Type: "usb", Managed: "yes"}
+	if byAddress {
+		Hostdev.Source.Address = &struct {
+			Bus    uint `xml:"bus,attr"`
+			Device uint `xml:"device,attr"`
+		}{Bus: dev.Bus, Device: dev.Device}
+	} else {
+		Hostdev.Source.Vendor = &struct {
+			Id string `xml:"id,attr"`
+		}{Id: dev.VendorId}
+		Hostdev.Source.Product = &struct {
+			Id string `xml:"id,attr"`
+		}{Id: dev.ProductId}
+	}
+
+	desc, err := xml.Marshal(Hostdev)
+	return string(desc), err
+}
+
+var errNoUsbDevice = errors.New("give a usb device by --vendor and --product, or by --usb-address")
+
+// FindUsbDevice returns the one host usb device given by vendor and product, or by address.
+func FindUsbDevice(vendor string, product string, address string) (UsbDevice, error) {
+	if address == "" && (vendor == "" || product == "") {
+		return UsbDevice{}, errNoUsbDevice
+	}
+
+	devices, err := FindUsbDevices(vendor, product, address)
+	switch {
+	case err != nil:
+		return UsbDevice{}, err
+	case len(devices) == 0:
+		return UsbDevice{}, errors.New("no host usb device matches, see node-device-list --cap usb_device")
+	case len(devices) > 1:
+		return UsbDevice{}, fmt.Errorf("%v host usb devices match, pick one by --usb-address", len(devices))
+	}
+	return devices[0], nil
+}
+
+// VirtualMachineAttachUsb passes a host usb device, e.g. a license dongle, through to a VM.
+func VirtualMachineAttachUsb(vm string, vendor string, product string, address string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	dev, err := FindUsbDevice(vendor, product, address)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	desc, err := UsbHostdev(dev, address != "")
+	herr(err)
+
+	err = d.AttachDeviceFlags(desc, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hret(dev)
+}
+
+// VirtualMachineDetachUsb takes a host usb device away from a VM. It is given the same way it was attached.
+// The device need not be plugged in anymore when given by vendor and product.
+func VirtualMachineDetachUsb(vm string, vendor string, product string, address string) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	var dev UsbDevice
+	if address == "" && (vendor == "" || product == "") {
+		err = errNoUsbDevice
+	} else if address != "" {
+		dev, err = FindUsbDevice(vendor, product, address)
+	} else if dev.VendorId, err = ParseUsbId(vendor); err == nil {
+		dev.ProductId, err = ParseUsbId(product)
+	}
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	desc, err := UsbHostdev(dev, address != "")
+	herr(err)
+
+	err = d.DetachDeviceFlags(desc, DeviceModifyFlags())
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	hok(fmt.Sprintf("usb device was detached from %v", vm))
+}
+
+type VirtualMachineUsbRedir struct {
+	// Channels is how many usb devices a spice client may redirect into the vm at once.
+	Channels      int
+	Controller    string
+	RestartNeeded bool
+}
+
+// VirtualMachineSetUsbRedir sets how many usb devices a spice client may redirect into a VM. A usb controller is added
+// when the VM has none. 0 removes the redirection channels.
+func VirtualMachineSetUsbRedir(vm string, count uint) {
+	d, err := libvirtInstance.LookupDomainByName(vm)
+	herr(err)
+	if err != nil {
+		return
+	}
+	defer FreeDomain(d)()
+
+	err = RedefineDomain(d, func(DomXML *XMLNode) error {
+		devices := DomXML.EnsureChild("devices")
+
+		spice := false
+		for _, graphics := range devices.Children("graphics") {
+			spice = spice || graphics.Attr("type") == "spice"
+		}
+		if !spice && count > 0 {
+			return fmt.Errorf("%v has no spice graphics, usb redirection works over spice only", vm)
+		}
+
+		// other redirdevs, e.g. over tcp, are not ours to touch.
+		kept := devices.Nodes[:0]
+		for _, device := range devices.Nodes {
+			if device.XMLName.Local != "redirdev" || device.Attr("type") != "spicevmc" {
+				kept = append(kept, device)
+			}
+		}
+		devices.Nodes = kept
+		if count == 0 {
+			return nil
+		}
+
+		HasController := false
+		for _, controller := range devices.Children("controller") {
+			if controller.Attr("type") == "usb" {
+				if controller.Attr("model") == "none" {
+					return fmt.Errorf("%v has usb turned off, its usb controller model is none", vm)
+				}
+				HasController = true
+			}
+		}
+		if !HasController {
+			devices.Nodes = append(devices.Nodes, NewXMLNode("controller", "type", "usb", "model", "qemu-xhci", "ports", "15"))
+		}
+
+		for i := uint(0); i < count; i++ {
+			devices.Nodes = append(devices.Nodes, NewXMLNode("redirdev", "bus", "usb", "type", "spicevmc"))
+		}
+		return nil
+	})
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	VirtualMachineGetUsbRedir(d)
+}
+
+// VirtualMachineGetUsbRedir returns the spice usb redirection channels of a VM.
+func VirtualMachineGetUsbRedir(d *libvirt.Domain) {
+	DomXML, err := GetDomainXMLNode(d, libvirt.DOMAIN_XML_INACTIVE)
+	herr(err)
+	if err != nil {
+		return
+	}
+
+	ret := VirtualMachineUsbRedir{RestartNeeded: RestartNeeded(d)}
+	devices := DomXML.EnsureChild("devices")
+	for _, redirdev := range devices.Children("redirdev") {
+		if redirdev.Attr("type") == "spicevmc" {
+			ret.Channels++
+		}
+	}
+	for _, controller := range devices.Children("controller") {
+		if controller.Attr("type") == "usb" && ret.Controller == "" {
+			ret.Controller = controller.Attr("model")
+		}
+	}
+
+	hret(ret)
+}
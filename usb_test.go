@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestParseUsbId(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "046d", want: "0x046d"},
+		{id: "0x046d", want: "0x046d"},
+		{id: "0X046D", want: "0x046d"},
+		{id: "c52b", want: "0xc52b"},
+		{id: "1", want: "0x0001"},
+		{id: "ffff", want: "0xffff"},
+		{id: "", wantErr: true},
+		{id: "0x", wantErr: true},
+		{id: "10000", wantErr: true},
+		{id: "046g", wantErr: true},
+		{id: "046d:c52b", wantErr: true},
+		{id: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseUsbId(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUsbId(%q) error = %v, want error %v", tt.id, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseUsbId(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestParseUsbAddress(t *testing.T) {
+	tests := []struct {
+		address    string
+		wantBus    uint
+		wantDevice uint
+		wantErr    bool
+	}{
+		{address: "001:004", wantBus: 1, wantDevice: 4},
+		{address: "3:12", wantBus: 3, wantDevice: 12},
+		// lsusb prints decimal, so 010 is ten and not eight.
+		{address: "010:010", wantBus: 10, wantDevice: 10},
+		{address: "", wantErr: true},
+		{address: "001", wantErr: true},
+		{address: "001:", wantErr: true},
+		{address: ":004", wantErr: true},
+		{address: "001:004:1", wantErr: true},
+		{address: "0x1:0x4", wantErr: true},
+		{address: "001.004", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		bus, device, err := ParseUsbAddress(tt.address)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUsbAddress(%q) error = %v, want error %v", tt.address, err, tt.wantErr)
+			continue
+		}
+		if bus != tt.wantBus || device != tt.wantDevice {
+			t.Errorf("ParseUsbAddress(%q) = %v, %v, want %v, %v", tt.address, bus, device, tt.wantBus, tt.wantDevice)
+		}
+	}
+}
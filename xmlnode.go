@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// XMLNode is a generic xml element. Unlike the partial models it keeps every element and attribute it does not know,
+// so a document can be changed in one place and written back without losing the rest.
+type XMLNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []XMLNode  `xml:",any"`
+	Text    string     `xml:",chardata"`
+}
+
+// ParseXMLNode parses an xml document into a node tree.
+func ParseXMLNode(desc string) (*XMLNode, error) {
+	var Node XMLNode
+	if err := xml.Unmarshal([]byte(desc), &Node); err != nil {
+		return nil, err
+	}
+	Node.clean()
+	return &Node, nil
+}
+
+// clean drops namespace declarations, encoding/xml writes them back as plain attributes otherwise, and whitespace
+// between child elements, which would all end up after the last child.
+func (n *XMLNode) clean() {
+	attrs := n.Attrs[:0]
+	for _, attr := range n.Attrs {
+		if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attrs = attrs
+
+	if len(n.Nodes) > 0 && strings.TrimSpace(n.Text) == "" {
+		n.Text = ""
+	}
+	for i := range n.Nodes {
+		n.Nodes[i].clean()
+	}
+}
+
+// String writes the node tree back as xml.
+func (n *XMLNode) String() (string, error) {
+	desc, err := xml.Marshal(n)
+	return string(desc), err
+}
+
+// Child returns the first child element with a given name, or nil.
+func (n *XMLNode) Child(name string) *XMLNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// SetChildText sets the text of the first child element with a given name, adding the element when it is missing.
+func (n *XMLNode) SetChildText(name string, text string) {
+	if child := n.Child(name); child != nil {
+		child.Text = text
+		return
+	}
+	n.Nodes = append(n.Nodes, XMLNode{XMLName: xml.Name{Local: name}, Text: text})
+}
+
+// NewXMLNode makes an element with attributes given as name value pairs, e.g. NewXMLNode("boot", "dev", "hd").
+func NewXMLNode(name string, attrs ...string) XMLNode {
+	Node := XMLNode{XMLName: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		Node.Attrs = append(Node.Attrs, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	return Node
+}
+
+// Attr returns the value of an attribute, or empty when it is missing.
+func (n *XMLNode) Attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// SetAttr sets an attribute, adding it when it is missing. An empty value removes it.
+func (n *XMLNode) SetAttr(name string, value string) {
+	for i, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			if value == "" {
+				n.Attrs = append(n.Attrs[:i], n.Attrs[i+1:]...)
+			} else {
+				n.Attrs[i].Value = value
+			}
+			return
+		}
+	}
+	if value != "" {
+		n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+}
+
+// Children returns all child elements with a given name.
+func (n *XMLNode) Children(name string) []*XMLNode {
+	var ret []*XMLNode
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			ret = append(ret, &n.Nodes[i])
+		}
+	}
+	return ret
+}
+
+// RemoveChildren drops all child elements with a given name and returns how many there were.
+func (n *XMLNode) RemoveChildren(name string) int {
+	nodes := n.Nodes[:0]
+	for _, node := range n.Nodes {
+		if node.XMLName.Local != name {
+			nodes = append(nodes, node)
+		}
+	}
+	removed := len(n.Nodes) - len(nodes)
+	n.Nodes = nodes
+	return removed
+}
+
+// EnsureChild returns the first child element with a given name, adding an empty one when it is missing.
+func (n *XMLNode) EnsureChild(name string) *XMLNode {
+	if child := n.Child(name); child != nil {
+		return child
+	}
+	n.Nodes = append(n.Nodes, NewXMLNode(name))
+	return &n.Nodes[len(n.Nodes)-1]
+}
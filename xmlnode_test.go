@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestXMLNodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		desc string
+		want string
+	}{
+		{
+			desc: `<domain type="kvm"><name>vm1</name><memory unit="KiB">1048576</memory></domain>`,
+			want: `<domain type="kvm"><name>vm1</name><memory unit="KiB">1048576</memory></domain>`,
+		},
+		{
+			desc: "<domain>\n  <name>vm1</name>\n  <devices>\n    <disk type=\"file\"/>\n  </devices>\n</domain>\n",
+			want: `<domain><name>vm1</name><devices><disk type="file"></disk></devices></domain>`,
+		},
+		{
+			desc: `<domain><description>a &amp; b &lt;c&gt;</description></domain>`,
+			want: `<domain><description>a &amp; b &lt;c&gt;</description></domain>`,
+		},
+		{
+			desc: `<domain><metadata><app:info xmlns:app="http://example.org/app" owner="ops"/></metadata></domain>`,
+			want: `<domain><metadata><info xmlns="http://example.org/app" owner="ops"></info></metadata></domain>`,
+		},
+	}
+
+	for _, tt := range tests {
+		node, err := ParseXMLNode(tt.desc)
+		if err != nil {
+			t.Errorf("ParseXMLNode(%q) error = %v", tt.desc, err)
+			continue
+		}
+		got, err := node.String()
+		if err != nil {
+			t.Errorf("String() of %q error = %v", tt.desc, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseXMLNode(%q).String() = %v, want %v", tt.desc, got, tt.want)
+			continue
+		}
+
+		// written back output parses into the same document again.
+		again, err := ParseXMLNode(got)
+		if err != nil {
+			t.Errorf("ParseXMLNode(%q) error = %v", got, err)
+			continue
+		}
+		if desc, _ := again.String(); desc != got {
+			t.Errorf("ParseXMLNode(%q).String() = %v, want %v", got, desc, got)
+		}
+	}
+
+	if _, err := ParseXMLNode("<domain><name>vm1</domain>"); err == nil {
+		t.Errorf("ParseXMLNode of unbalanced xml gave no error")
+	}
+}
+
+func TestXMLNodeEdit(t *testing.T) {
+	node, err := ParseXMLNode(`<domain type="kvm"><name>vm1</name><os><boot dev="hd"/><boot dev="cdrom"/></os></domain>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.SetAttr("type", "qemu")
+	node.SetAttr("id", "5")
+	node.SetAttr("id", "")
+	node.SetChildText("name", "vm2")
+	node.SetChildText("title", "web")
+	osNode := node.EnsureChild("os")
+	if removed := osNode.RemoveChildren("boot"); removed != 2 {
+		t.Errorf("RemoveChildren(boot) = %v, want 2", removed)
+	}
+	osNode.Nodes = append(osNode.Nodes, NewXMLNode("boot", "dev", "network"))
+	node.EnsureChild("features").EnsureChild("acpi")
+
+	want := `<domain type="qemu"><name>vm2</name><os><boot dev="network"></boot></os><title>web</title><features><acpi></acpi></features></domain>`
+	if got, _ := node.String(); got != want {
+		t.Errorf("edited node = %v, want %v", got, want)
+	}
+	if got := node.Attr("id"); got != "" {
+		t.Errorf("Attr(id) = %q after removing it", got)
+	}
+	if got := len(node.Children("os")); got != 1 {
+		t.Errorf("len(Children(os)) = %v, EnsureChild added a second one", got)
+	}
+}